@@ -48,3 +48,33 @@ func SecretValue() KeyValue {
 func IsSecret(spec OptionSpec) bool {
 	return spec.Annotations.Has("system-conf/secret")
 }
+
+// SpecifierValue returns an annotation KeyValue that marks
+// an option as supporting specifiers. See ReplaceDeclaredSpecifiers.
+func SpecifierValue() KeyValue {
+	return KeyValue{
+		Key:   "system-conf/specifiers",
+		Value: true,
+	}
+}
+
+// HasSpecifiers returns true if spec is annotated to support
+// specifiers.
+func HasSpecifiers(spec OptionSpec) bool {
+	return spec.Annotations.Has("system-conf/specifiers")
+}
+
+// QuotedValue returns an annotation KeyValue that marks an option
+// as supporting quoting and C-style escape sequences. See UnquoteValues
+// and Quote.
+func QuotedValue() KeyValue {
+	return KeyValue{
+		Key:   "system-conf/quoted",
+		Value: true,
+	}
+}
+
+// IsQuoted returns true if spec is annotated to support quoting.
+func IsQuoted(spec OptionSpec) bool {
+	return spec.Annotations.Has("system-conf/quoted")
+}
@@ -69,6 +69,13 @@ func decodeFileToStruct(file *File, spec SectionRegistry, outVal reflect.Value)
 			}
 		}
 
+		if isSectionPattern(name) {
+			if err := decodePatternSections(file, spec, name, required, outVal.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+
 		secSpec, ok := spec.OptionsForSection(strings.ToLower(name))
 		if !ok {
 			return fmt.Errorf("no specification for section %q", name)
@@ -91,6 +98,82 @@ func decodeFileToStruct(file *File, spec SectionRegistry, outVal reflect.Value)
 	return nil
 }
 
+// decodePatternSections decodes all sections of file whose name matches
+// pattern into outVal. outVal must be a slice or a map with string keys.
+// Maps are keyed by the section name so sections that are defined
+// multiple times, for example with different arguments, can only be
+// decoded into slices. Each section is decoded using its own
+// specification from spec.
+func decodePatternSections(file *File, spec SectionRegistry, pattern string, required bool, outVal reflect.Value) error {
+	var sections Sections
+	for _, sec := range file.Sections {
+		if matchSectionPattern(pattern, strings.ToLower(sec.Name)) {
+			sections = append(sections, sec)
+		}
+	}
+
+	if len(sections) == 0 {
+		if required {
+			return fmt.Errorf("required section %q is missing", pattern)
+		}
+		return nil
+	}
+
+	valType := outVal.Type()
+	switch valType.Kind() {
+	case reflect.Slice:
+		sliceVal := reflect.MakeSlice(valType, len(sections), len(sections))
+		for idx, sec := range sections {
+			if err := decodePatternSection(sec, spec, sliceVal.Index(idx)); err != nil {
+				return err
+			}
+		}
+		outVal.Set(sliceVal)
+
+	case reflect.Map:
+		if valType.Key().Kind() != reflect.String {
+			return fmt.Errorf("failed to decode sections %s: map keys must be of type %s", pattern, reflect.String)
+		}
+
+		mapVal := reflect.MakeMap(valType)
+		seen := make(map[string]bool)
+		for _, sec := range sections {
+			key := strings.ToLower(sec.Name)
+			if seen[key] {
+				return fmt.Errorf("failed to decode sections %s: section %s is defined multiple times, use a %s instead", pattern, sec.Name, reflect.Slice)
+			}
+			seen[key] = true
+
+			elem := reflect.New(valType.Elem()).Elem()
+			if err := decodePatternSection(sec, spec, elem); err != nil {
+				return err
+			}
+			mapVal.SetMapIndex(reflect.ValueOf(sec.Name).Convert(valType.Key()), elem)
+		}
+		outVal.Set(mapVal)
+
+	default:
+		return fmt.Errorf("failed to decode sections %s: target must be of type %s or %s", pattern, reflect.Slice, reflect.Map)
+	}
+
+	return nil
+}
+
+// decodePatternSection decodes sec into outVal using the specification
+// returned by spec for the name of sec.
+func decodePatternSection(sec Section, spec SectionRegistry, outVal reflect.Value) error {
+	secSpec, ok := spec.OptionsForSection(strings.ToLower(sec.Name))
+	if !ok {
+		return fmt.Errorf("no specification for section %q", sec.Name)
+	}
+
+	if err := decodeSections(Sections{sec}, secSpec, outVal); err != nil {
+		return fmt.Errorf("failed to decode section %s: %w", sec.Name, err)
+	}
+
+	return nil
+}
+
 func decodeSections(sections Sections, spec OptionRegistry, outVal reflect.Value) error {
 	kind := getKind(outVal)
 
@@ -206,7 +289,20 @@ func decodeSectionToStruct(section Section, spec OptionRegistry, outVal reflect.
 		}
 
 		if optionValue, ok := fieldType.Tag.Lookup("option"); ok && optionValue != "" {
-			name = optionValue
+			parts := strings.Split(optionValue, ",")
+			if len(parts) > 1 && parts[1] == "arg" {
+				// the field receives the argument of the section
+				// header instead of an option value.
+				if getKind(outVal.Field(i)) != reflect.String {
+					return fmt.Errorf("failed to unmarshal section argument into field %s: must be of type %s", fieldType.Name, reflect.String)
+				}
+				outVal.Field(i).SetString(section.Arg)
+				continue
+			}
+
+			if parts[0] != "" {
+				name = parts[0]
+			}
 			if name == "-" {
 				continue
 			}
@@ -218,7 +314,10 @@ func decodeSectionToStruct(section Section, spec OptionRegistry, outVal reflect.
 			continue
 		}
 
-		values := section.GetStringSlice(optionSpec.Name)
+		values, err := section.GetSpecValues(optionSpec)
+		if err != nil {
+			return fmt.Errorf("failed to split values of %s: %w", optionSpec.Name, err)
+		}
 		if len(values) == 0 && !optionSpec.Required {
 			continue
 		}
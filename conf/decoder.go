@@ -21,12 +21,19 @@ func DecodeValues(data []string, specType OptionType, receiver interface{}) erro
 }
 
 // DecodeSections decodes a slice of sections into receiver. Only options defined
-// in registry are allowed and permitted.
+// in registry are allowed and permitted. A string field tagged with
+// `option:",arg"` receives the argument of the section header (see
+// ParseConfig.SectionArgs).
 func DecodeSections(sections []Section, registry OptionRegistry, receiver interface{}) error {
 	return decodeSections(sections, registry, reflect.ValueOf(receiver).Elem())
 }
 
-// Decode a file into target following the file specification.
+// Decode a file into target following the file specification. Fields
+// may use a glob pattern as their section name, like
+// `section:"Plugin.*"`, to receive all matching sections. Such fields
+// must be slices or maps keyed by the section name (see PatternSpec).
+// Decoding into a map fails if a matching section is defined multiple
+// times.
 func DecodeFile(file *File, target interface{}, spec SectionRegistry) error {
 	return decodeFile(file, spec, reflect.ValueOf(target).Elem())
 }
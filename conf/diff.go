@@ -0,0 +1,336 @@
+package conf
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DiffOp describes the kind of difference between two files.
+type DiffOp int
+
+// All supported diff operations.
+const (
+	DiffAdded DiffOp = iota + 1
+	DiffRemoved
+	DiffModified
+)
+
+func (op DiffOp) String() string {
+	switch op {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffModified:
+		return "modified"
+	}
+	return fmt.Sprintf("DiffOp(%d)", int(op))
+}
+
+// OptionDiff describes the difference of a single option.
+type OptionDiff struct {
+	// Name is the name of the option.
+	Name string
+
+	// Op is the kind of difference.
+	Op DiffOp
+
+	// Old holds all values of the option in the old section.
+	Old []string
+
+	// New holds all values of the option in the new section.
+	New []string
+}
+
+// SectionDiff describes the difference of a single section.
+type SectionDiff struct {
+	// Name is the name of the section.
+	Name string
+
+	// Arg is the argument of the section header, if any (see
+	// Section.Arg).
+	Arg string
+
+	// Index is the index of the section among all sections with
+	// the same name and argument. Sections that are defined multiple
+	// times are compared index-wise.
+	Index int
+
+	// Op is the kind of difference.
+	Op DiffOp
+
+	// Options holds the differences of all options. For added and
+	// removed sections all options are reported as added or removed.
+	Options []OptionDiff
+}
+
+// FileDiff describes the differences between two files.
+type FileDiff []SectionDiff
+
+// Empty returns true if there are no differences.
+func (d FileDiff) Empty() bool {
+	return len(d) == 0
+}
+
+// DiffFiles returns the differences between old and new. Section and
+// option names are compared using equal fold while section arguments
+// must match exactly. Sections that are defined multiple times are
+// compared index-wise, i.e. the n-th section of old is compared to the
+// n-th section with the same name and argument in new. The order
+// of option values is significant but the order of options within a
+// section is not. Either old or new may be nil.
+func DiffFiles(old, new *File) FileDiff {
+	var oldSections, newSections Sections
+	if old != nil {
+		oldSections = old.Sections
+	}
+	if new != nil {
+		newSections = new.Sections
+	}
+
+	return DiffSections(oldSections, newSections)
+}
+
+// DiffSections is like DiffFiles but operates on sections. Differences
+// are reported in the order sections appear in old followed by all
+// sections that have been added in new.
+func DiffSections(old, new Sections) FileDiff {
+	var result FileDiff
+
+	oldIdx := indexSections(old)
+	newIdx := indexSections(new)
+
+	for _, ref := range oldIdx.order {
+		oldSec := oldIdx.sections[ref]
+		newSec, ok := newIdx.sections[ref]
+		if !ok {
+			result = append(result, SectionDiff{
+				Name:    oldSec.Name,
+				Arg:     oldSec.Arg,
+				Index:   ref.index,
+				Op:      DiffRemoved,
+				Options: DiffOptions(oldSec.Options, nil),
+			})
+			continue
+		}
+
+		if opts := DiffOptions(oldSec.Options, newSec.Options); len(opts) > 0 {
+			result = append(result, SectionDiff{
+				Name:    newSec.Name,
+				Arg:     newSec.Arg,
+				Index:   ref.index,
+				Op:      DiffModified,
+				Options: opts,
+			})
+		}
+	}
+
+	for _, ref := range newIdx.order {
+		if _, ok := oldIdx.sections[ref]; ok {
+			continue
+		}
+
+		newSec := newIdx.sections[ref]
+		result = append(result, SectionDiff{
+			Name:    newSec.Name,
+			Arg:     newSec.Arg,
+			Index:   ref.index,
+			Op:      DiffAdded,
+			Options: DiffOptions(nil, newSec.Options),
+		})
+	}
+
+	return result
+}
+
+// DiffOptions returns the differences between the options old and new.
+// Option names are compared using equal fold and all values of an option
+// are compared as a whole. Differences are reported in the order options
+// first appear in old followed by all options added in new.
+func DiffOptions(old, new Options) []OptionDiff {
+	var result []OptionDiff
+
+	oldOrder, oldValues := groupOptions(old)
+	newOrder, newValues := groupOptions(new)
+
+	for _, key := range oldOrder {
+		oldVals := oldValues[key]
+		newVals, ok := newValues[key]
+		switch {
+		case !ok:
+			result = append(result, OptionDiff{
+				Name: oldVals.name,
+				Op:   DiffRemoved,
+				Old:  oldVals.values,
+			})
+		case !stringsEqual(oldVals.values, newVals.values):
+			result = append(result, OptionDiff{
+				Name: newVals.name,
+				Op:   DiffModified,
+				Old:  oldVals.values,
+				New:  newVals.values,
+			})
+		}
+	}
+
+	for _, key := range newOrder {
+		if _, ok := oldValues[key]; ok {
+			continue
+		}
+
+		newVals := newValues[key]
+		result = append(result, OptionDiff{
+			Name: newVals.name,
+			Op:   DiffAdded,
+			New:  newVals.values,
+		})
+	}
+
+	return result
+}
+
+// Render writes a human readable representation of d to w. It is
+// meant for logging purposes. The values of all options that are marked
+// as secret in reg (see IsSecret) are masked. reg may be nil.
+// FileDiff does not implement fmt.Stringer on purpose so values
+// cannot be logged without masking by accident.
+func (d FileDiff) Render(w io.Writer, reg SectionRegistry) error {
+	for _, sec := range d {
+		var optReg OptionRegistry
+		if reg != nil {
+			optReg, _ = reg.OptionsForSection(strings.ToLower(sec.Name))
+		}
+
+		name := Section{Name: sec.Name, Arg: sec.Arg}.Header()
+		if sec.Index > 0 {
+			name = fmt.Sprintf("%s#%d", name, sec.Index)
+		}
+
+		if _, err := fmt.Fprintf(w, "%s [%s]\n", diffSymbol(sec.Op), name); err != nil {
+			return err
+		}
+
+		for _, opt := range sec.Options {
+			secret := false
+			if optReg != nil {
+				if spec, ok := optReg.GetOption(strings.ToLower(opt.Name)); ok {
+					secret = IsSecret(spec)
+				}
+			}
+
+			var line string
+			switch opt.Op {
+			case DiffAdded:
+				line = fmt.Sprintf("%s=%s", opt.Name, formatDiffValues(opt.New, secret))
+			case DiffRemoved:
+				line = fmt.Sprintf("%s=%s", opt.Name, formatDiffValues(opt.Old, secret))
+			default:
+				line = fmt.Sprintf("%s=%s -> %s", opt.Name, formatDiffValues(opt.Old, secret), formatDiffValues(opt.New, secret))
+			}
+
+			if _, err := fmt.Fprintf(w, "  %s %s\n", diffSymbol(opt.Op), line); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func diffSymbol(op DiffOp) string {
+	switch op {
+	case DiffAdded:
+		return "+"
+	case DiffRemoved:
+		return "-"
+	default:
+		return "~"
+	}
+}
+
+func formatDiffValues(values []string, secret bool) string {
+	formatted := make([]string, len(values))
+	for idx, val := range values {
+		if secret {
+			formatted[idx] = secretMask
+		} else {
+			formatted[idx] = fmt.Sprintf("%q", val)
+		}
+	}
+
+	if len(formatted) == 1 {
+		return formatted[0]
+	}
+	return "[" + strings.Join(formatted, ", ") + "]"
+}
+
+type sectionRef struct {
+	name  string
+	arg   string
+	index int
+}
+
+type sectionIndex struct {
+	order    []sectionRef
+	sections map[sectionRef]Section
+}
+
+// indexSections indexes sections by their lower-case name, their
+// argument and their index among sections with the same name and
+// argument.
+func indexSections(sections Sections) sectionIndex {
+	idx := sectionIndex{
+		sections: make(map[sectionRef]Section),
+	}
+
+	count := make(map[sectionRef]int)
+	for _, sec := range sections {
+		key := sectionRef{name: strings.ToLower(sec.Name), arg: sec.Arg}
+		ref := key
+		ref.index = count[key]
+		count[key]++
+
+		idx.order = append(idx.order, ref)
+		idx.sections[ref] = sec
+	}
+
+	return idx
+}
+
+type optionValues struct {
+	name   string
+	values []string
+}
+
+// groupOptions groups all option values by the lower-case
+// option name.
+func groupOptions(opts Options) ([]string, map[string]optionValues) {
+	var order []string
+	values := make(map[string]optionValues)
+
+	for _, opt := range opts {
+		key := strings.ToLower(opt.Name)
+		ov, ok := values[key]
+		if !ok {
+			order = append(order, key)
+			ov.name = opt.Name
+		}
+		ov.values = append(ov.values, opt.Value)
+		values[key] = ov
+	}
+
+	return order, values
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
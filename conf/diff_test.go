@@ -0,0 +1,119 @@
+package conf_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ppacher/system-conf/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffFiles(t *testing.T) {
+	old := &conf.File{
+		Sections: conf.Sections{
+			{
+				Name: "Global",
+				Options: conf.Options{
+					{Name: "Name", Value: "old"},
+					{Name: "Tags", Value: "a"},
+					{Name: "Tags", Value: "b"},
+					{Name: "Removed", Value: "x"},
+					{Name: "Same", Value: "same"},
+				},
+			},
+			{
+				Name: "Listener",
+				Options: conf.Options{
+					{Name: "Address", Value: ":80"},
+				},
+			},
+			{
+				Name: "Listener",
+				Options: conf.Options{
+					{Name: "Address", Value: ":443"},
+				},
+			},
+		},
+	}
+
+	updated := &conf.File{
+		Sections: conf.Sections{
+			{
+				Name: "listener",
+				Options: conf.Options{
+					{Name: "address", Value: ":80"},
+				},
+			},
+			{
+				Name: "GLOBAL",
+				Options: conf.Options{
+					{Name: "same", Value: "same"},
+					{Name: "Tags", Value: "a"},
+					{Name: "Name", Value: "new"},
+					{Name: "Added", Value: "y"},
+				},
+			},
+			{
+				Name: "Auth",
+				Options: conf.Options{
+					{Name: "Password", Value: "s3cr3t"},
+				},
+			},
+		},
+	}
+
+	diff := conf.DiffFiles(old, updated)
+	assert.Equal(t, conf.FileDiff{
+		{
+			Name: "GLOBAL",
+			Op:   conf.DiffModified,
+			Options: []conf.OptionDiff{
+				{Name: "Name", Op: conf.DiffModified, Old: []string{"old"}, New: []string{"new"}},
+				{Name: "Tags", Op: conf.DiffModified, Old: []string{"a", "b"}, New: []string{"a"}},
+				{Name: "Removed", Op: conf.DiffRemoved, Old: []string{"x"}},
+				{Name: "Added", Op: conf.DiffAdded, New: []string{"y"}},
+			},
+		},
+		{
+			Name:  "Listener",
+			Index: 1,
+			Op:    conf.DiffRemoved,
+			Options: []conf.OptionDiff{
+				{Name: "Address", Op: conf.DiffRemoved, Old: []string{":443"}},
+			},
+		},
+		{
+			Name: "Auth",
+			Op:   conf.DiffAdded,
+			Options: []conf.OptionDiff{
+				{Name: "Password", Op: conf.DiffAdded, New: []string{"s3cr3t"}},
+			},
+		},
+	}, diff)
+
+	assert.True(t, conf.DiffFiles(old, old.Clone()).Empty())
+	assert.Len(t, conf.DiffFiles(nil, updated), 3)
+
+	spec := conf.FileSpec{
+		"auth": conf.SectionSpec{
+			{
+				Name:        "Password",
+				Type:        conf.StringType,
+				Annotations: new(conf.Annotation).With(conf.SecretValue()),
+			},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, diff.Render(buf, spec))
+	assert.Equal(t, `~ [GLOBAL]
+  ~ Name="old" -> "new"
+  ~ Tags=["a", "b"] -> "a"
+  - Removed="x"
+  + Added="y"
+- [Listener#1]
+  - Address=":443"
++ [Auth]
+  + Password=<secret>
+`, buf.String())
+}
@@ -1,9 +1,11 @@
 package conf
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"io/fs"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -15,39 +17,45 @@ const DropInExt = ".conf"
 // DropIn is a drop-in file for a given system-deploy task.
 type DropIn File
 
-// readDir is used to read the contents of a directory and return
-// a slice of os.FileInfo for each directory entry. It's here for
-// unit-testing purposes and nomally points to ioutil.ReadDir.
-var readDir func(path string) ([]os.FileInfo, error) = ioutil.ReadDir
-
 // ApplyDropIns applies all dropins on t. DropIns can only be applied
-// to files with unique section names. That is, if a file specifies
-// the same section multiple times (like multiple [Copy] sections),
-// drop-ins cannot be applied to that file.
+// to sections that are unique within t. Sections are identified by
+// their name and argument (see Section.Arg) so a drop-in may update
+// [Remote "origin"] even if t also has [Remote "upstream"]. If a file
+// specifies the same section multiple times (like multiple [Copy]
+// sections), drop-ins for that section fail with
+// ErrDropInSectionNotAllowed.
+// Options are merged in a deterministic order: all options of t keep
+// their position, even if a drop-in updates them, while options that
+// t does not have yet are appended in the order they appear in the
+// drop-in file.
 func ApplyDropIns(t *File, dropins []*DropIn, secReg SectionRegistry) error {
-	slm := make(map[string]*Section)
+	slm := make(map[sectionKey]*Section)
 
 	for idx := range t.Sections {
 		sec := t.Sections[idx]
-		sn := strings.ToLower(sec.Name)
-		if _, ok := slm[sn]; ok {
+		key := keyOfSection(sec)
+		if _, ok := slm[key]; ok {
 			// that section is defined multiple times
 			// so instead of setting it we nil it.
-			slm[sn] = nil
+			slm[key] = nil
 			continue
 		}
 
-		slm[sn] = &sec
+		slm[key] = &sec
 	}
 
 	for _, d := range dropins {
 		for _, dropInSec := range d.Sections {
-			sn := strings.ToLower(dropInSec.Name)
+			key := keyOfSection(dropInSec)
+			sn := key.name
 
-			s, ok := slm[sn]
+			s, ok := slm[key]
 			if !ok {
 				return fmt.Errorf("%s: %w", sn, ErrDropInSectionNotExists)
 			}
+			if s == nil {
+				return fmt.Errorf("%s: %w", sn, ErrDropInSectionNotAllowed)
+			}
 
 			sectionSpec, ok := secReg.OptionsForSection(sn)
 			if sectionSpec == nil || !ok {
@@ -62,7 +70,7 @@ func ApplyDropIns(t *File, dropins []*DropIn, secReg SectionRegistry) error {
 
 	// rebuild the section slice.
 	for idx, sec := range t.Sections {
-		val := slm[strings.ToLower(sec.Name)]
+		val := slm[keyOfSection(sec)]
 		if val != nil {
 			t.Sections[idx] = *val
 		}
@@ -71,46 +79,155 @@ func ApplyDropIns(t *File, dropins []*DropIn, secReg SectionRegistry) error {
 	return nil
 }
 
+// sectionKey identifies a section by its lower-case name
+// and its argument.
+type sectionKey struct {
+	name string
+	arg  string
+}
+
+func keyOfSection(sec Section) sectionKey {
+	return sectionKey{name: strings.ToLower(sec.Name), arg: sec.Arg}
+}
+
+// mergeSections merges all options from dropInSec into s. Options of
+// s keep their order: values of non-slice options and slice options
+// that are reset are replaced in place while values appended to a slice
+// option are inserted after its last value. Options that s does not
+// have yet are appended in the order they first appear in the drop-in
+// section. This guarantees a stable result for the same input. Values
+// are merged as they are assigned, even for options that declare a list
+// split mode (see OptionSpec.SplitValues).
 func mergeSections(s *Section, dropInSec Section, optReg OptionRegistry) error {
 	if optReg == nil {
 		return ErrNoOptions
 	}
 	// build a lookup map for the option values in this
-	// drop-in section
+	// drop-in section and remember the order in which
+	// option names appear.
 	olm := make(map[string][]Option)
+	var order []string
 	for _, opt := range dropInSec.Options {
 		on := strings.ToLower(opt.Name)
+		if _, ok := olm[on]; !ok {
+			order = append(order, on)
+		}
 		olm[on] = append(olm[on], opt)
 	}
 
 	// update each option, one after the other
-	for optName, opts := range olm {
-		optLowerName := strings.ToLower(optName)
+	for _, optLowerName := range order {
+		opts := olm[optLowerName]
 		optSpec, ok := optReg.GetOption(optLowerName)
 		if !ok {
-			return fmt.Errorf("%s: %w", optName, ErrOptionNotExists)
+			return fmt.Errorf("%s: %w", opts[0].Name, ErrOptionNotExists)
 		}
 
 		// if the first value is empty it means we should
 		// remove all current values in a slice type.
 		// If it's not a slice type we are going to overwrite the existing
-		// value so we can also remove it.
-		if !optSpec.Type.IsSliceType() || opts[0].Value == "" {
-			var newOpts Options
-			for _, opt := range s.Options {
-				if strings.ToLower(opt.Name) != optLowerName {
-					newOpts = append(newOpts, opt)
-				}
-			}
-			s.Options = newOpts
+		// value.
+		replace := !optSpec.Type.IsSliceType() || opts[0].Value == ""
+		if optSpec.Type.IsSliceType() && opts[0].Value == "" {
+			opts = opts[1:]
+		}
+
+		s.Options = mergeOption(s.Options, optLowerName, opts, replace)
+
+		// line numbers of the drop-in would point into the wrong
+		// file so merged values don't have one. Values that are
+		// appended come after all existing values so only replaced
+		// values need to be forgotten.
+		if _, ok := s.OptionLines[optLowerName]; ok && replace {
+			s.OptionLines = cloneOptionLines(s.OptionLines)
+			delete(s.OptionLines, optLowerName)
+		}
+	}
+
+	return nil
+}
+
+// mergeOption merges the values opts of the option name into options.
+// If replace is true, all existing values are replaced by opts at the
+// position of the first existing value. Otherwise, opts are inserted
+// after the last existing value. If options does not contain name yet,
+// opts are appended.
+func mergeOption(options Options, name string, opts []Option, replace bool) Options {
+	last := -1
+	for idx, opt := range options {
+		if strings.ToLower(opt.Name) == name {
+			last = idx
+		}
+	}
+	if last == -1 {
+		return append(options, opts...)
+	}
+
+	var (
+		result   Options
+		inserted bool
+	)
+	for idx, opt := range options {
+		isOption := strings.ToLower(opt.Name) == name
+
+		if !replace || !isOption {
+			result = append(result, opt)
+		}
+
+		if isOption && !inserted && (replace || idx == last) {
+			result = append(result, opts...)
+			inserted = true
+		}
+	}
+
+	return result
+}
+
+// OverlayFile applies all sections of layer on top of f using the same
+// semantics as ApplyDropIns: values of non-slice options are replaced,
+// values of slice options are appended and an empty value resets a slice
+// option. Other than ApplyDropIns, OverlayFile supports sections that
+// are defined multiple times by merging them index-wise. That is, the
+// n-th section of layer with a given name and argument is merged into
+// the n-th section of f with the same name and argument. Sections of
+// layer that do not exist in f are appended. This allows to use a File
+// created by ParseFromEnv as just another configuration layer.
+func OverlayFile(f *File, layer *File, secReg SectionRegistry) error {
+	seen := make(map[sectionKey]int)
+
+	for _, layerSec := range layer.Sections {
+		key := keyOfSection(layerSec)
+		sn := key.name
+
+		sectionSpec, ok := secReg.OptionsForSection(sn)
+		if !ok {
+			return fmt.Errorf("%s: %w", layerSec.Name, ErrUnknownSection)
+		}
 
-			if optSpec.Type.IsSliceType() {
-				opts = opts[1:]
+		idx := seen[key]
+		seen[key]++
+
+		var target *Section
+		count := 0
+		for secIdx := range f.Sections {
+			if keyOfSection(f.Sections[secIdx]) != key {
+				continue
+			}
+			if count == idx {
+				target = &f.Sections[secIdx]
+				break
 			}
+			count++
 		}
 
-		// add the new values to the list
-		s.Options = append(s.Options, opts...)
+		if target == nil {
+			f.Sections = append(f.Sections, Section{Name: layerSec.Name, Arg: layerSec.Arg})
+			target = &f.Sections[len(f.Sections)-1]
+		}
+
+		if err := mergeSections(target, layerSec, sectionSpec); err != nil {
+			return fmt.Errorf("%s: %w", layerSec.Name, err)
+		}
 	}
 
 	return nil
@@ -119,15 +236,32 @@ func mergeSections(s *Section, dropInSec Section, optReg OptionRegistry) error {
 // LoadDropIns loads all drop-in files for unitName. See SearchDropInFiles
 // and DropInSearchPaths for more information on the searchPath.
 func LoadDropIns(unitName string, searchPath []string) ([]*DropIn, error) {
-	files, err := SearchDropinFiles(unitName, searchPath)
+	return LoadDropInsFS(osFS{}, unitName, searchPath)
+}
+
+// LoadDropInsFS is like LoadDropIns but loads all drop-in files
+// from fsys.
+func LoadDropInsFS(fsys fs.FS, unitName string, searchPath []string) ([]*DropIn, error) {
+	return LoadDropInsContext(context.Background(), fsys, unitName, searchPath)
+}
+
+// LoadDropInsContext is like LoadDropInsFS but stops loading once ctx
+// is cancelled and enforces the limits configured in opts for each
+// drop-in file.
+func LoadDropInsContext(ctx context.Context, fsys fs.FS, unitName string, searchPath []string, opts ...ParseConfig) ([]*DropIn, error) {
+	files, err := SearchDropinFilesFS(fsys, unitName, searchPath)
 	if err != nil {
 		return nil, err
 	}
 
 	dropins := make([]*DropIn, len(files))
 	for idx, filePath := range files {
-		t, err := LoadFile(filePath)
+		t, err := LoadFileContext(ctx, fsys, filePath, opts...)
 		if err != nil && (err != ErrNoSections) {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+
 			// don't ignore ErrNotExist here because
 			// it existed just a few seconds ago!
 			return nil, fmt.Errorf("%s: %w", filePath, err)
@@ -147,13 +281,22 @@ func LoadDropIns(unitName string, searchPath []string) ([]*DropIn, error) {
 // "/etc/system-deploy" then a /etc/system-deploy/<unit>/10-overwrite.conf would
 // overwrite /var/lib/system-deploy/<unit>/10-overwrite.conf.
 func SearchDropinFiles(unitName string, searchPath []string) ([]string, error) {
+	return SearchDropinFilesFS(osFS{}, unitName, searchPath)
+}
+
+// SearchDropinFilesFS is like SearchDropinFiles but searches fsys.
+// Paths in searchPath must be valid fs.FS paths unless fsys accepts
+// otherwise (see fs.ValidPath).
+func SearchDropinFilesFS(fsys fs.FS, unitName string, searchPath []string) ([]string, error) {
 	files := make(map[string]string)
 
-	for _, path := range searchPath {
-		unitPaths := DropInSearchPaths(unitName, path)
+	for _, root := range searchPath {
+		unitPaths := DropInSearchPaths(unitName, root)
 		for _, sp := range unitPaths {
-			dirFiles, err := readDir(sp)
-			if os.IsNotExist(err) {
+			sp = filepath.ToSlash(sp)
+
+			dirFiles, err := fs.ReadDir(fsys, sp)
+			if errors.Is(err, fs.ErrNotExist) {
 				continue
 			}
 
@@ -164,7 +307,7 @@ func SearchDropinFiles(unitName string, searchPath []string) ([]string, error) {
 			for _, file := range dirFiles {
 				n := file.Name()
 				if !file.IsDir() && strings.HasSuffix(n, DropInExt) {
-					files[n] = filepath.Join(sp, n)
+					files[n] = path.Join(sp, n)
 				}
 			}
 		}
@@ -193,6 +336,10 @@ func SearchDropinFiles(unitName string, searchPath []string) ([]string, error) {
 // the search path for foo-bar.task will result in the following search
 // path: <rootDir>/foo-.task.d/, <rootDir>/foo-bar.task.d/. If the unitName
 // contains an extension (like .task), it is used for <rootDir>/task.d/ as well.
+// If unitName is a template instance (like foo-bar@baz.task) the template
+// drop-in directory <rootDir>/foo-bar@.task.d/ is searched right before the
+// instance specific one. Like systemd, dashes are only considered in the
+// prefix of a template instance name so the instance part is never split.
 // The returned search path is already sorted by priority where the first search
 // path has lowest and the last search path has highest priority.
 func DropInSearchPaths(unitName string, rootDir string) []string {
@@ -207,8 +354,16 @@ func DropInSearchPaths(unitName string, rootDir string) []string {
 		)
 	}
 
+	// for template instances only the prefix (the part before the @)
+	// is split at dashes.
+	prefix := name
+	instance, isTemplate := TemplateInstanceName(unitName)
+	if isTemplate {
+		prefix = strings.TrimSuffix(name, "@"+instance)
+	}
+
 	// add <rootDir>/foo-.task.d and <rootDir>/foo-bar-.task.d
-	parts := strings.SplitAfter(name, "-")
+	parts := strings.SplitAfter(prefix, "-")
 	for idx := 0; idx < len(parts)-1; idx++ {
 		paths = append(
 			paths,
@@ -219,6 +374,11 @@ func DropInSearchPaths(unitName string, rootDir string) []string {
 		)
 	}
 
+	// add <rootDir>/foo-bar-baz@.task.d
+	if isTemplate && instance != "" {
+		paths = append(paths, filepath.Join(rootDir, prefix+"@"+ext+".d"))
+	}
+
 	// add <rootDir>/foo-bar-baz.task.d
 	paths = append(paths, filepath.Join(rootDir, unitName+".d"))
 	return paths
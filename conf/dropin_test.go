@@ -1,13 +1,12 @@
 package conf
 
 import (
-	"io/ioutil"
-	"os"
 	"strings"
 	"testing"
-	"time"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDropInSearchPaths(t *testing.T) {
@@ -21,64 +20,92 @@ func TestDropInSearchPaths(t *testing.T) {
 	}, paths)
 }
 
-type fakeFileInfo struct {
-	name  string
-	isDir bool
-}
-
-func (t *fakeFileInfo) IsDir() bool {
-	return t.isDir
-}
-func (t *fakeFileInfo) Name() string {
-	return t.name
-}
-func (*fakeFileInfo) ModTime() time.Time { return time.Now() }
-func (*fakeFileInfo) Mode() os.FileMode  { return 0600 }
-func (*fakeFileInfo) Size() int64        { return 100 }
-func (*fakeFileInfo) Sys() interface{}   { return nil }
+func TestDropInSearchPathsTemplate(t *testing.T) {
+	cases := []struct {
+		Unit     string
+		Expected []string
+	}{
+		{
+			"web@blue.service",
+			[]string{
+				"/lib/service.d",
+				"/lib/web@.service.d",
+				"/lib/web@blue.service.d",
+			},
+		},
+		{
+			"foo-bar@baz-1.service",
+			[]string{
+				"/lib/service.d",
+				"/lib/foo-.service.d",
+				"/lib/foo-bar@.service.d",
+				"/lib/foo-bar@baz-1.service.d",
+			},
+		},
+		{
+			// the template itself does not have an instance
+			"foo-bar@.service",
+			[]string{
+				"/lib/service.d",
+				"/lib/foo-.service.d",
+				"/lib/foo-bar@.service.d",
+			},
+		},
+		{
+			"web@a@b.service",
+			[]string{
+				"/lib/service.d",
+				"/lib/web@.service.d",
+				"/lib/web@a@b.service.d",
+			},
+		},
+	}
 
-func fakeFile(name string, dir bool) os.FileInfo {
-	return &fakeFileInfo{name, dir}
+	for idx, c := range cases {
+		assert.Equal(t, c.Expected, DropInSearchPaths(c.Unit, "/lib/"), "case #%d (%s)", idx, c.Unit)
+	}
 }
 
 func TestSearchDropinFiles(t *testing.T) {
-	// restore readDir after this test case
-	defer func() {
-		readDir = ioutil.ReadDir
-	}()
-	readDir = func(path string) ([]os.FileInfo, error) {
-		switch {
-		case strings.HasPrefix(path, "/lib/task.d"):
-			return []os.FileInfo{
-				fakeFile("test", false),
-				fakeFile("dir.conf", true),
-				fakeFile("10-overwrite.conf", false),
-				fakeFile("20-task.d.conf", false),
-			}, nil
-		case strings.HasPrefix(path, "/lib/foo-.task.d"):
-			return []os.FileInfo{
-				fakeFile("test2", false),
-				fakeFile("10-overwrite.conf", false),
-				fakeFile("30-foo-task.d.conf", false),
-			}, nil
-		case strings.HasPrefix(path, "/lib/foo-bar-baz.task.d"):
-			return []os.FileInfo{
-				fakeFile("10-overwrite.conf", false),
-			}, nil
-		}
-
-		return nil, os.ErrNotExist
+	fsys := fstest.MapFS{
+		"lib/task.d/test":                          {},
+		"lib/task.d/dir.conf/file":                 {},
+		"lib/task.d/10-overwrite.conf":             {},
+		"lib/task.d/20-task.d.conf":                {},
+		"lib/foo-.task.d/test2":                    {},
+		"lib/foo-.task.d/10-overwrite.conf":        {},
+		"lib/foo-.task.d/30-foo-task.d.conf":       {},
+		"lib/foo-bar-baz.task.d/10-overwrite.conf": {},
 	}
 
-	paths, err := SearchDropinFiles("foo-bar-baz.task", []string{"/lib/"})
+	paths, err := SearchDropinFilesFS(fsys, "foo-bar-baz.task", []string{"lib"})
 	assert.NoError(t, err)
 	assert.Equal(t, []string{
-		"/lib/foo-bar-baz.task.d/10-overwrite.conf",
-		"/lib/task.d/20-task.d.conf",
-		"/lib/foo-.task.d/30-foo-task.d.conf",
+		"lib/foo-bar-baz.task.d/10-overwrite.conf",
+		"lib/task.d/20-task.d.conf",
+		"lib/foo-.task.d/30-foo-task.d.conf",
 	}, paths)
 }
 
+func TestLoadDropInsLayeredFS(t *testing.T) {
+	embedded := fstest.MapFS{
+		"lib/test.task.d/10-default.conf": {Data: []byte("[Test]\nSingle=embedded\n")},
+		"lib/test.task.d/20-other.conf":   {Data: []byte("[Test]\nSlice1=embedded\n")},
+	}
+	disk := fstest.MapFS{
+		"lib/test.task.d/20-other.conf": {Data: []byte("[Test]\nSlice1=disk\n")},
+	}
+
+	dropins, err := LoadDropInsFS(LayeredFS{embedded, disk}, "test.task", []string{"lib"})
+	assert.NoError(t, err)
+	if assert.Len(t, dropins, 2) {
+		assert.Equal(t, "lib/test.task.d/10-default.conf", dropins[0].Path)
+		assert.Equal(t, "embedded", dropins[0].Sections[0].Options[0].Value)
+		assert.Equal(t, "lib/test.task.d/20-other.conf", dropins[1].Path)
+		assert.Equal(t, "disk", dropins[1].Sections[0].Options[0].Value)
+	}
+}
+
 func TestApplyDropIns(t *testing.T) {
 	specs := FileSpec{
 		"test": SectionSpec{
@@ -170,16 +197,16 @@ func TestApplyDropIns(t *testing.T) {
 						Value: "d1",
 					},
 					{
-						Name:  "Slice2",
-						Value: "d1",
+						Name:  "Slice1",
+						Value: "d2",
 					},
 					{
 						Name:  "Slice2",
 						Value: "d1",
 					},
 					{
-						Name:  "Slice1",
-						Value: "d2",
+						Name:  "Slice2",
+						Value: "d1",
 					},
 				},
 			},
@@ -187,6 +214,41 @@ func TestApplyDropIns(t *testing.T) {
 	}, res)
 }
 
+func TestApplyDropInsOptionLines(t *testing.T) {
+	specs := FileSpec{
+		"test": SectionSpec{
+			{Name: "Single", Type: StringType},
+			{Name: "Slice1", Type: StringSliceType},
+			{Name: "Slice2", Type: StringSliceType},
+		},
+	}
+
+	f, err := Deserialize("", strings.NewReader("[Test]\nSingle=a\nSlice1=a\nSlice2=a\n"))
+	require.NoError(t, err)
+	orig := f.Clone()
+
+	require.NoError(t, ApplyDropIns(f, []*DropIn{
+		{Sections: Sections{{Name: "Test", Options: Options{
+			{Name: "Single", Value: "b"},
+			{Name: "Slice1", Value: "b"},
+			{Name: "Slice2", Value: ""},
+			{Name: "Slice2", Value: "b"},
+		}}}},
+	}, specs))
+
+	sec := f.Sections[0]
+	// line numbers of the base file are kept unless the
+	// values are replaced by the drop-in.
+	assert.Equal(t, 0, sec.OptionLine("Single", 0))
+	assert.Equal(t, 3, sec.OptionLine("Slice1", 0))
+	assert.Equal(t, 0, sec.OptionLine("Slice1", 1))
+	assert.Equal(t, 0, sec.OptionLine("Slice2", 0))
+
+	// the original file is not modified.
+	assert.Equal(t, 2, orig.Sections[0].OptionLine("single", 0))
+	assert.Equal(t, 4, orig.Sections[0].OptionLine("slice2", 0))
+}
+
 func TestApplyDropInsNotAllowed(t *testing.T) {
 	tsk := &File{
 		Sections: []Section{
@@ -271,3 +333,63 @@ func TestApplyDropInsOptionNotExists(t *testing.T) {
 
 	assert.Error(t, err)
 }
+
+func TestApplyDropInsStableOrder(t *testing.T) {
+	specs := FileSpec{
+		"test": SectionSpec{
+			{Name: "A", Type: StringType},
+			{Name: "B", Type: StringType},
+			{Name: "C", Type: StringSliceType},
+			{Name: "D", Type: StringType},
+			{Name: "E", Type: StringSliceType},
+		},
+	}
+
+	base := &File{
+		Sections: []Section{
+			{
+				Name: "Test",
+				Options: Options{
+					{Name: "A", Value: "base"},
+					{Name: "B", Value: "base"},
+					{Name: "C", Value: "base"},
+				},
+			},
+		},
+	}
+
+	d1 := &DropIn{
+		Sections: []Section{
+			{
+				Name: "Test",
+				Options: Options{
+					{Name: "E", Value: "d1"},
+					{Name: "A", Value: "d1"},
+					{Name: "D", Value: "d1"},
+					{Name: "C", Value: "d1"},
+					{Name: "E", Value: "d1-2"},
+				},
+			},
+		},
+	}
+
+	// base options keep their position, new options are
+	// appended in drop-in order.
+	expected := Options{
+		{Name: "A", Value: "d1"},
+		{Name: "B", Value: "base"},
+		{Name: "C", Value: "base"},
+		{Name: "C", Value: "d1"},
+		{Name: "E", Value: "d1"},
+		{Name: "E", Value: "d1-2"},
+		{Name: "D", Value: "d1"},
+	}
+
+	// map iteration order is random so make sure we get the same
+	// result multiple times.
+	for i := 0; i < 20; i++ {
+		res := base.Clone()
+		assert.NoError(t, ApplyDropIns(res, []*DropIn{d1}, specs))
+		assert.Equal(t, expected, res.Sections[0].Options)
+	}
+}
@@ -9,15 +9,41 @@ import (
 	"unicode"
 )
 
+// WriteConfig can be passed to WriteSectionsTo to configure how
+// option values are written.
+type WriteConfig struct {
+	// Spec is used to find options that support quoting. If set, the
+	// values of all options annotated with QuotedValue() are quoted
+	// using Quote. Values of options that declare a list split mode
+	// are written as they are (see UnquoteValues).
+	Spec SectionRegistry
+}
+
 // WriteSectionsTo writes all sections to w .
-func WriteSectionsTo(sections Sections, w io.Writer) error {
+func WriteSectionsTo(sections Sections, w io.Writer, opts ...WriteConfig) error {
+	var cfg WriteConfig
+	if len(opts) > 0 {
+		cfg = opts[0]
+	}
+
 	for _, sec := range sections {
-		if _, err := fmt.Fprintf(w, "[%s]\n", sec.Name); err != nil {
+		if _, err := fmt.Fprintf(w, "[%s]\n", sec.Header()); err != nil {
 			return err
 		}
 
+		var optReg OptionRegistry
+		if cfg.Spec != nil {
+			optReg, _ = cfg.Spec.OptionsForSection(strings.ToLower(sec.Name))
+		}
+
 		for _, opt := range sec.Options {
-			escaped := strings.ReplaceAll(opt.Value, "\n", "\\\n\t")
+			var escaped string
+			if spec, ok := lookupOption(optReg, opt.Name); ok && IsQuoted(spec) && !spec.splitsValues() {
+				escaped = Quote(opt.Value)
+			} else {
+				escaped = strings.ReplaceAll(opt.Value, "\n", "\\\n\t")
+			}
+
 			if _, err := fmt.Fprintf(w, "%s= %s\n", opt.Name, escaped); err != nil {
 				return err
 			}
@@ -27,8 +53,19 @@ func WriteSectionsTo(sections Sections, w io.Writer) error {
 	return nil
 }
 
+// lookupOption returns the spec for the option name from optReg.
+// optReg may be nil.
+func lookupOption(optReg OptionRegistry, name string) (OptionSpec, bool) {
+	if optReg == nil {
+		return OptionSpec{}, false
+	}
+	return optReg.GetOption(strings.ToLower(name))
+}
+
 // ConvertToFile converts x to a File. x is expected to be or point to a struct
 // type.
+// String fields of section structs tagged with `option:",arg"` are
+// used as the argument of the section header (see Section.Arg).
 func ConvertToFile(x interface{}, path string) (*File, error) {
 	val := reflect.ValueOf(x)
 	f := &File{
@@ -123,6 +160,9 @@ func encodeSection(val reflect.Value, name string, result *File, opts *Options)
 		inline = false
 	}
 
+	// arg is the argument of the section header, if any.
+	var arg string
+
 	for i := 0; i < val.NumField(); i++ {
 		fieldValue := val.Field(i)
 		fieldType := val.Type().Field(i)
@@ -136,6 +176,16 @@ func encodeSection(val reflect.Value, name string, result *File, opts *Options)
 
 		if tagValue, ok := fieldType.Tag.Lookup("option"); ok {
 			parts := strings.Split(tagValue, ",")
+			if len(parts) > 1 && parts[1] == "arg" {
+				// the field holds the argument of the section
+				// header rather than an option.
+				if getKind(fieldValue) != reflect.String {
+					return fmt.Errorf("cannot encode section argument from field %s: must be of type %s", fieldType.Name, reflect.String)
+				}
+				arg = fieldValue.String()
+				continue
+			}
+
 			if parts[0] != "" {
 				name = parts[0]
 			}
@@ -150,9 +200,10 @@ func encodeSection(val reflect.Value, name string, result *File, opts *Options)
 		}
 	}
 
-	if !inline && len(*opts) > 0 {
+	if !inline && (len(*opts) > 0 || arg != "") {
 		result.Sections = append(result.Sections, Section{
 			Name:    name,
+			Arg:     arg,
 			Options: *opts,
 		})
 	}
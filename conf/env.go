@@ -8,40 +8,95 @@ import (
 	"github.com/google/shlex"
 )
 
-func ParseFromEnv(prefix string, env []string, reg SectionRegistry) (*File, error) {
+// EnvConfig can be passed to ParseFromEnv to configure how
+// environment variables are mapped to sections and options.
+type EnvConfig struct {
+	// Separator separates the prefix, section name, section index
+	// and option name in environment variable names. Defaults to
+	// "_". Use a separator like "__" if section or option names
+	// contain underscores and should be matched unambiguously.
+	Separator string
+
+	// IgnoreUnknown may be set to true to skip variables that
+	// start with the prefix but cannot be mapped to a known
+	// section and option.
+	IgnoreUnknown bool
+
+	// OnUnknown, if set, is called for each variable that starts
+	// with the prefix but cannot be mapped to a known section and
+	// option. Such variables are skipped.
+	OnUnknown func(varName string)
+
+	// ResetEmptySlices may be set to true to turn an empty value for
+	// a slice option into a single empty option value. Such a value
+	// resets the slice when the result is applied using OverlayFile
+	// but fails validation otherwise. If false, empty values for
+	// slice options are ignored. EnvLayer always sets it.
+	ResetEmptySlices bool
+}
+
+// ParseFromEnv parses all environment variables in env that start
+// with prefix into a File. Variables must be named like
+// PREFIX_SECTION_OPTION or PREFIX_SECTION_IDX_OPTION where IDX is
+// the index of the section if it's specified multiple times. Since
+// section and option names may contain the separator, the longest
+// section name known to reg that is followed by a known option name
+// is used. Values of slice options are split using shell quoting
+// rules. Empty values for slice options are ignored unless
+// ResetEmptySlices is set in opts. Sections are returned in the order
+// they are first seen in env.
+//
+// Variables for unknown sections are skipped while variables for
+// unknown options of a known section cause an error unless
+// IgnoreUnknown or OnUnknown is set in opts.
+func ParseFromEnv(prefix string, env []string, reg SectionRegistry, opts ...EnvConfig) (*File, error) {
+	var cfg EnvConfig
+	if len(opts) > 0 {
+		cfg = opts[0]
+	}
+	if cfg.Separator == "" {
+		cfg.Separator = "_"
+	}
+
 	envFile := new(File)
 
 	sections := make(map[string][]*Section)
+	// sectionOrder keeps track of the order in which section
+	// names are first seen so the result is stable.
+	var sectionOrder []string
 
 	order, lm := toMap(env)
 	for _, varName := range order {
 		varValue := lm[varName]
-		parts := strings.Split(varName, "_")
-		if !strings.EqualFold(parts[0], prefix) {
-			continue
-		}
 
-		if len(parts) < 3 {
-			// PREFIX_SECTION_KEY requires at least 3 parts.
+		if len(varName) < len(prefix)+len(cfg.Separator) ||
+			!strings.EqualFold(varName[:len(prefix)+len(cfg.Separator)], prefix+cfg.Separator) {
 			continue
 		}
-		sectionName := strings.ToLower(parts[1])
-		optName := parts[2]
-		sectionIdx := 0
 
-		if len(parts) >= 4 {
-			idx, err := strconv.ParseInt(parts[2], 10, 0)
-			if err == nil {
-				sectionIdx = int(idx)
-				optName = parts[3]
+		parts := strings.Split(varName[len(prefix)+len(cfg.Separator):], cfg.Separator)
+		if len(parts) < 2 {
+			// SECTION_KEY requires at least 2 parts after the prefix
+			// so the variable cannot belong to a known section.
+			if cfg.OnUnknown != nil {
+				cfg.OnUnknown(varName)
 			}
+			continue
 		}
 
-		optReg, ok := reg.OptionsForSection(sectionName)
-		if !ok {
-			// Skip unknown section name
+		sectionName, sectionIdx, optSpec, found, sectionKnown := matchEnvName(parts, cfg.Separator, reg)
+		if !found {
+			switch {
+			case cfg.OnUnknown != nil:
+				cfg.OnUnknown(varName)
+			case cfg.IgnoreUnknown || !sectionKnown:
+				// Skip unknown variables
+			default:
+				return nil, fmt.Errorf("invalid option name in %s for section %s", varName, sectionName)
+			}
 			continue
 		}
+		optName := optSpec.Name
 
 		var sec *Section
 		switch {
@@ -49,6 +104,9 @@ func ParseFromEnv(prefix string, env []string, reg SectionRegistry) (*File, erro
 			sec = &Section{
 				Name: sectionName,
 			}
+			if sectionIdx == 0 {
+				sectionOrder = append(sectionOrder, sectionName)
+			}
 			sections[sectionName] = append(sections[sectionName], sec)
 
 		case len(sections[sectionName]) < sectionIdx:
@@ -61,11 +119,6 @@ func ParseFromEnv(prefix string, env []string, reg SectionRegistry) (*File, erro
 			return nil, fmt.Errorf("cannot get section with index %d in %+v", sectionIdx, sections[sectionName])
 		}
 
-		optSpec, ok := optReg.GetOption(strings.ToLower(optName))
-		if !ok {
-			return nil, fmt.Errorf("invalid option name %s for section %s", optName, sectionName)
-		}
-
 		var values []string
 		if optSpec.Type.IsSliceType() {
 			var err error
@@ -73,6 +126,12 @@ func ParseFromEnv(prefix string, env []string, reg SectionRegistry) (*File, erro
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse option value for %s.%s: %w", sectionName, optName, err)
 			}
+
+			// an empty value resets the slice when used with
+			// OverlayFile, just like it does in drop-ins.
+			if len(values) == 0 && cfg.ResetEmptySlices {
+				values = []string{""}
+			}
 		} else {
 			values = []string{varValue}
 		}
@@ -86,8 +145,8 @@ func ParseFromEnv(prefix string, env []string, reg SectionRegistry) (*File, erro
 		}
 	}
 
-	for _, secs := range sections {
-		for _, sec := range secs {
+	for _, name := range sectionOrder {
+		for _, sec := range sections[name] {
 			envFile.Sections = append(envFile.Sections, *sec)
 		}
 	}
@@ -95,6 +154,162 @@ func ParseFromEnv(prefix string, env []string, reg SectionRegistry) (*File, erro
 	return envFile, nil
 }
 
+// EncodeToEnv is the inverse of ParseFromEnv and encodes all sections
+// and options of f into environment variables in the form of
+// PREFIX_SECTION_OPTION=value or PREFIX_SECTION_IDX_OPTION=value for
+// all but the first section with the same name. Variable names are
+// upper-cased. All values of slice options are encoded into a single
+// variable and quoted so they can be split using shell quoting rules
+// as done by ParseFromEnv. Only the Separator of opts is used.
+// Variables are returned in the order sections and options first
+// appear in f. Since variable names cannot hold section arguments,
+// sections with an argument (see Section.Arg) are rejected with
+// ErrSectionArgNotSupported.
+func EncodeToEnv(prefix string, f *File, reg SectionRegistry, opts ...EnvConfig) ([]string, error) {
+	sep := "_"
+	if len(opts) > 0 && opts[0].Separator != "" {
+		sep = opts[0].Separator
+	}
+
+	var env []string
+	sectionCount := make(map[string]int)
+
+	for _, sec := range f.Sections {
+		sn := strings.ToLower(sec.Name)
+
+		if sec.Arg != "" {
+			return nil, fmt.Errorf("%s: %w", sec.Header(), ErrSectionArgNotSupported)
+		}
+
+		optReg, ok := reg.OptionsForSection(sn)
+		if !ok || optReg == nil {
+			return nil, fmt.Errorf("%s: %w", sec.Name, ErrUnknownSection)
+		}
+
+		namePrefix := prefix + sep + sec.Name + sep
+		if idx := sectionCount[sn]; idx > 0 {
+			namePrefix += strconv.Itoa(idx) + sep
+		}
+		sectionCount[sn]++
+
+		// group values by option name but keep the order
+		// in which options are first seen.
+		var order []string
+		values := make(map[string][]string)
+		for _, opt := range sec.Options {
+			on := strings.ToLower(opt.Name)
+			if _, ok := values[on]; !ok {
+				order = append(order, on)
+			}
+			values[on] = append(values[on], opt.Value)
+		}
+
+		for _, on := range order {
+			spec, ok := optReg.GetOption(on)
+			if !ok {
+				return nil, fmt.Errorf("%s.%s: %w", sec.Name, on, ErrOptionNotExists)
+			}
+
+			var value string
+			if spec.Type.IsSliceType() {
+				value = shellJoin(values[on])
+			} else {
+				if len(values[on]) > 1 {
+					return nil, fmt.Errorf("%s.%s: %w", sec.Name, spec.Name, ErrOptionAllowedOnce)
+				}
+				value = values[on][0]
+			}
+
+			env = append(env, strings.ToUpper(namePrefix+spec.Name)+"="+value)
+		}
+	}
+
+	return env, nil
+}
+
+// shellJoin joins values into a single string that is split
+// into the same values by shlex.Split. A slice consisting of
+// a single empty value is encoded as an empty string.
+func shellJoin(values []string) string {
+	if len(values) == 1 && values[0] == "" {
+		return ""
+	}
+
+	quoted := make([]string, len(values))
+	for idx, val := range values {
+		quoted[idx] = shellQuote(val)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes s using single quotes if it contains
+// characters that have a special meaning for shlex.Split.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	safe := true
+	for _, r := range s {
+		if !isShellSafe(r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+func isShellSafe(r rune) bool {
+	return (r >= 'a' && r <= 'z') ||
+		(r >= 'A' && r <= 'Z') ||
+		(r >= '0' && r <= '9') ||
+		strings.ContainsRune("_@%+=:,./-", r)
+}
+
+// matchEnvName maps the parts of a variable name (without the prefix)
+// to a section name, section index and option spec. The longest section
+// name known to reg that is followed by a known option (optionally
+// preceded by a section index) is used. If no option matches, found is
+// false and sectionKnown reports whether at least a section matched.
+// In that case sectionName holds the longest matching section name.
+func matchEnvName(parts []string, sep string, reg SectionRegistry) (sectionName string, idx int, spec OptionSpec, found bool, sectionKnown bool) {
+	for k := len(parts) - 1; k > 0; k-- {
+		candidate := strings.ToLower(strings.Join(parts[:k], sep))
+
+		optReg, ok := reg.OptionsForSection(candidate)
+		if !ok || optReg == nil {
+			continue
+		}
+
+		if !sectionKnown {
+			sectionKnown = true
+			sectionName = candidate
+		}
+
+		rest := parts[k:]
+		if len(rest) >= 2 {
+			// only accept plain decimal indexes. Signs would
+			// allow negative indexes.
+			if i, err := strconv.ParseUint(rest[0], 10, 31); err == nil {
+				if spec, ok := optReg.GetOption(strings.ToLower(strings.Join(rest[1:], sep))); ok {
+					return candidate, int(i), spec, true, true
+				}
+			}
+		}
+
+		if spec, ok := optReg.GetOption(strings.ToLower(strings.Join(rest, sep))); ok {
+			return candidate, 0, spec, true, true
+		}
+	}
+
+	return sectionName, 0, OptionSpec{}, false, sectionKnown
+}
+
 func toMap(env []string) ([]string, map[string]string) {
 	r := map[string]string{}
 	order := []string{}
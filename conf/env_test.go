@@ -1,10 +1,12 @@
 package conf_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/ppacher/system-conf/conf"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseEnv(t *testing.T) {
@@ -48,3 +50,333 @@ func TestParseEnv(t *testing.T) {
 	assert.Equal(t, []string{"first", "second"}, f.GetAll("bar")[0].GetStringSlice("slice"))
 	assert.Equal(t, []string{"third", "forth"}, f.GetAll("bar")[1].GetStringSlice("slice"))
 }
+
+func TestParseEnvUnderscores(t *testing.T) {
+	fileSpec := conf.FileSpec{
+		"http": conf.SectionSpec{
+			{Name: "server_name", Type: conf.StringType},
+		},
+		"http_server": conf.SectionSpec{
+			{Name: "max_conns", Type: conf.IntType},
+			{Name: "name", Type: conf.StringType},
+		},
+	}
+
+	f, err := conf.ParseFromEnv("TEST", []string{
+		"TEST_HTTP_SERVER_NAME=http",
+		"TEST_HTTP_SERVER_MAX_CONNS=10",
+		"TEST_HTTP_SERVER_1_MAX_CONNS=20",
+	}, fileSpec)
+	assert.NoError(t, err)
+
+	assert.Len(t, f.GetAll("http_server"), 2)
+	assert.Equal(t, []string{"10"}, f.GetAll("http_server")[0].GetStringSlice("max_conns"))
+	assert.Equal(t, []string{"20"}, f.GetAll("http_server")[1].GetStringSlice("max_conns"))
+	assert.Equal(t, []string{"http"}, f.GetAll("http_server")[0].GetStringSlice("name"))
+	assert.Nil(t, f.Get("http"))
+
+	// use a custom separator
+	f, err = conf.ParseFromEnv("MY_APP", []string{
+		"MY_APP__HTTP__SERVER_NAME=foo",
+		"MY_APP__HTTP_SERVER__NAME=baz",
+		"MY_APP__HTTP_SERVER__1__NAME=bar",
+	}, fileSpec, conf.EnvConfig{Separator: "__"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, f.Get("http").GetStringSlice("server_name"))
+	assert.Len(t, f.GetAll("http_server"), 2)
+	assert.Equal(t, []string{"baz"}, f.GetAll("http_server")[0].GetStringSlice("name"))
+	assert.Equal(t, []string{"bar"}, f.GetAll("http_server")[1].GetStringSlice("name"))
+}
+
+func TestParseEnvUnknown(t *testing.T) {
+	fileSpec := conf.FileSpec{
+		"foo": conf.SectionSpec{
+			{Name: "String", Type: conf.StringType},
+		},
+	}
+
+	env := []string{
+		"TEST_FOO_STRING=value",
+		"TEST_FOO_UNKNOWN=value",
+		"TEST_BAR_STRING=value",
+		"TEST_FOO=value",
+		"TEST_=value",
+	}
+
+	_, err := conf.ParseFromEnv("TEST", env, fileSpec)
+	assert.Error(t, err)
+
+	f, err := conf.ParseFromEnv("TEST", env, fileSpec, conf.EnvConfig{IgnoreUnknown: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"value"}, f.Get("foo").GetStringSlice("string"))
+
+	var unknown []string
+	f, err = conf.ParseFromEnv("TEST", env, fileSpec, conf.EnvConfig{
+		OnUnknown: func(name string) {
+			unknown = append(unknown, name)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"value"}, f.Get("foo").GetStringSlice("string"))
+	assert.Equal(t, []string{"TEST_FOO_UNKNOWN", "TEST_BAR_STRING", "TEST_FOO", "TEST_"}, unknown)
+}
+
+func TestParseEnvEmptySlice(t *testing.T) {
+	fileSpec := conf.FileSpec{
+		"s": conf.SectionSpec{
+			{Name: "Ports", Type: conf.IntSliceType},
+			{Name: "Tags", Type: conf.StringSliceType},
+		},
+	}
+	env := []string{"APP_S_PORTS=", "APP_S_TAGS="}
+
+	// empty slice values are ignored by default so the
+	// result still validates.
+	f, err := conf.ParseFromEnv("APP", env, fileSpec)
+	require.NoError(t, err)
+	assert.NoError(t, conf.ValidateFile(f, fileSpec))
+
+	var target struct {
+		S struct {
+			Ports []int
+			Tags  []string
+		}
+	}
+	require.NoError(t, conf.DecodeFile(f, &target, fileSpec))
+	assert.Nil(t, target.S.Tags)
+
+	f, err = conf.ParseFromEnv("APP", env, fileSpec, conf.EnvConfig{ResetEmptySlices: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{""}, f.Get("s").GetStringSlice("Tags"))
+}
+
+func TestParseEnvInvalidIndex(t *testing.T) {
+	fileSpec := conf.FileSpec{
+		"s": conf.SectionSpec{
+			{Name: "Ports", Type: conf.IntSliceType},
+		},
+	}
+
+	for _, idx := range []string{"-1", "+1", "0x1", "99999999999999999999"} {
+		assert.NotPanics(t, func() {
+			_, err := conf.ParseFromEnv("APP", []string{"APP_S_" + idx + "_PORTS=1"}, fileSpec)
+			assert.Error(t, err, idx)
+		}, idx)
+	}
+}
+
+func TestParseEnvStableOrder(t *testing.T) {
+	fileSpec := conf.FileSpec{}
+	var env []string
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		fileSpec[name] = conf.SectionSpec{
+			{Name: "Value", Type: conf.StringType},
+		}
+		env = append(env, "TEST_"+name+"_VALUE="+name)
+	}
+
+	for i := 0; i < 10; i++ {
+		f, err := conf.ParseFromEnv("TEST", env, fileSpec)
+		assert.NoError(t, err)
+
+		var names []string
+		for _, sec := range f.Sections {
+			names = append(names, sec.Name)
+		}
+		assert.Equal(t, []string{"a", "b", "c", "d", "e", "f", "g", "h"}, names)
+	}
+}
+
+func TestOverlayEnv(t *testing.T) {
+	fileSpec := conf.FileSpec{
+		"global": conf.SectionSpec{
+			{Name: "Name", Type: conf.StringType},
+			{Name: "Tags", Type: conf.StringSliceType},
+			{Name: "Extra", Type: conf.StringSliceType},
+		},
+		"listener": conf.SectionSpec{
+			{Name: "Address", Type: conf.StringType},
+		},
+	}
+
+	base := &conf.File{
+		Sections: conf.Sections{
+			{
+				Name: "Global",
+				Options: conf.Options{
+					{Name: "Name", Value: "file"},
+					{Name: "Tags", Value: "a"},
+					{Name: "Extra", Value: "x"},
+				},
+			},
+			{
+				Name: "Listener",
+				Options: conf.Options{
+					{Name: "Address", Value: ":80"},
+				},
+			},
+		},
+	}
+
+	envFile, err := conf.ParseFromEnv("TEST", []string{
+		"TEST_GLOBAL_NAME=env",
+		"TEST_GLOBAL_TAGS=b c",
+		"TEST_GLOBAL_EXTRA=",
+		"TEST_LISTENER_ADDRESS=:8080",
+		"TEST_LISTENER_1_ADDRESS=:443",
+	}, fileSpec, conf.EnvConfig{ResetEmptySlices: true})
+	assert.NoError(t, err)
+
+	assert.NoError(t, conf.OverlayFile(base, envFile, fileSpec))
+	assert.Equal(t, &conf.File{
+		Sections: conf.Sections{
+			{
+				Name: "Global",
+				Options: conf.Options{
+					{Name: "Name", Value: "env"},
+					{Name: "Tags", Value: "a"},
+					{Name: "Tags", Value: "b"},
+					{Name: "Tags", Value: "c"},
+				},
+			},
+			{
+				Name: "Listener",
+				Options: conf.Options{
+					{Name: "Address", Value: ":8080"},
+				},
+			},
+			{
+				Name: "listener",
+				Options: conf.Options{
+					{Name: "Address", Value: ":443"},
+				},
+			},
+		},
+	}, base)
+}
+
+func TestEncodeToEnvRoundTrip(t *testing.T) {
+	fileSpec := conf.FileSpec{
+		"global": conf.SectionSpec{
+			{Name: "Name", Type: conf.StringType},
+			{Name: "Tags", Type: conf.StringSliceType},
+			{Name: "Ports", Type: conf.IntSliceType},
+		},
+		"http_server": conf.SectionSpec{
+			{Name: "Max_Conns", Type: conf.IntType},
+			{Name: "Paths", Type: conf.StringSliceType},
+		},
+	}
+
+	cases := []struct {
+		Env []string
+		Cfg conf.EnvConfig
+	}{
+		{
+			Env: []string{
+				"TEST_GLOBAL_NAME=some value with spaces and 'quotes'",
+				"TEST_GLOBAL_TAGS=a 'b c' ''",
+				"TEST_GLOBAL_PORTS=80 443",
+				"TEST_HTTP_SERVER_MAX_CONNS=10",
+				"TEST_HTTP_SERVER_PATHS='/srv/my '\"'\"'data'\"'\"'' '#comment' $HOME",
+				"TEST_HTTP_SERVER_1_MAX_CONNS=20",
+			},
+		},
+		{
+			Env: []string{
+				"TEST_GLOBAL_TAGS=",
+				"TEST_HTTP_SERVER_PATHS='line1\nline2'",
+			},
+			Cfg: conf.EnvConfig{ResetEmptySlices: true},
+		},
+		{
+			Env: []string{
+				"MY_APP__HTTP_SERVER__MAX_CONNS=10",
+				"MY_APP__HTTP_SERVER__1__PATHS=a b",
+			},
+			Cfg: conf.EnvConfig{Separator: "__"},
+		},
+	}
+
+	for idx, c := range cases {
+		prefix := "TEST"
+		if c.Cfg.Separator == "__" {
+			prefix = "MY_APP"
+		}
+
+		f, err := conf.ParseFromEnv(prefix, c.Env, fileSpec, c.Cfg)
+		if !assert.NoError(t, err, "case #%d", idx) {
+			continue
+		}
+
+		env, err := conf.EncodeToEnv(prefix, f, fileSpec, c.Cfg)
+		if !assert.NoError(t, err, "case #%d", idx) {
+			continue
+		}
+
+		// env -> File -> env must be stable
+		f2, err := conf.ParseFromEnv(prefix, env, fileSpec, c.Cfg)
+		if !assert.NoError(t, err, "case #%d", idx) {
+			continue
+		}
+		assert.Equal(t, f, f2, "case #%d", idx)
+
+		env2, err := conf.EncodeToEnv(prefix, f2, fileSpec, c.Cfg)
+		assert.NoError(t, err, "case #%d", idx)
+		assert.Equal(t, env, env2, "case #%d", idx)
+	}
+}
+
+func TestEncodeToEnv(t *testing.T) {
+	fileSpec := conf.FileSpec{
+		"global": conf.SectionSpec{
+			{Name: "Name", Type: conf.StringType},
+			{Name: "Tags", Type: conf.StringSliceType},
+		},
+	}
+
+	env, err := conf.EncodeToEnv("TEST", &conf.File{
+		Sections: conf.Sections{
+			{
+				Name: "Global",
+				Options: conf.Options{
+					{Name: "Tags", Value: "a"},
+					{Name: "Name", Value: "it's me"},
+					{Name: "Tags", Value: "b c"},
+				},
+			},
+			{
+				Name: "Global",
+				Options: conf.Options{
+					{Name: "Name", Value: "second"},
+				},
+			},
+		},
+	}, fileSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"TEST_GLOBAL_TAGS=a 'b c'",
+		"TEST_GLOBAL_NAME=it's me",
+		"TEST_GLOBAL_1_NAME=second",
+	}, env)
+
+	_, err = conf.EncodeToEnv("TEST", &conf.File{
+		Sections: conf.Sections{
+			{Name: "Unknown"},
+		},
+	}, fileSpec)
+	assert.True(t, errors.Is(err, conf.ErrUnknownSection))
+
+	_, err = conf.EncodeToEnv("TEST", &conf.File{
+		Sections: conf.Sections{
+			{
+				Name: "Global",
+				Options: conf.Options{
+					{Name: "Name", Value: "a"},
+					{Name: "Name", Value: "b"},
+				},
+			},
+		},
+	}, fileSpec)
+	assert.True(t, errors.Is(err, conf.ErrOptionAllowedOnce))
+}
@@ -16,4 +16,15 @@ var (
 	ErrDropInSectionNotExists  = errors.New("section defined in drop-in does not exist")
 	ErrDropInSectionNotAllowed = errors.New("drop-ins not allowed for not-unique sections")
 	ErrNoOptions               = errors.New("no options defined")
+	ErrNotTemplateInstance     = errors.New("not a template instance name")
+	ErrUnitNotFound            = errors.New("unit file not found")
+	ErrUnknownSpecifier        = errors.New("unknown specifier")
+	ErrUndefinedReference      = errors.New("undefined reference")
+	ErrReferenceCycle          = errors.New("reference cycle detected")
+	ErrLimitExceeded           = errors.New("limit exceeded")
+	ErrUnterminatedQuote       = errors.New("unterminated quote")
+	ErrInvalidEscape           = errors.New("invalid escape sequence")
+	ErrIncludeCycle            = errors.New("include cycle detected")
+	ErrIncludeNotAllowed       = errors.New("include not allowed")
+	ErrSectionArgNotSupported  = errors.New("section arguments not supported")
 )
@@ -0,0 +1,109 @@
+package conf
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// escapeValidChars holds all characters that are not escaped
+// by Escape. Note that '-' and '\' are still escaped.
+const escapeValidChars = "0123456789" +
+	"abcdefghijklmnopqrstuvwxyz" +
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+	":_."
+
+// Escape escapes s so it can be used as part of a unit name. It
+// follows the rules of systemd-escape: "/" is replaced by "-" and
+// all other characters that are not alpha-numerical or one of
+// ":", "_" or "." are replaced by their C-style "\x2d" escape.
+// A leading "." is escaped as well.
+func Escape(s string) string {
+	var b strings.Builder
+
+	for idx := 0; idx < len(s); idx++ {
+		c := s[idx]
+
+		switch {
+		case idx == 0 && c == '.':
+			fmt.Fprintf(&b, "\\x%02x", c)
+		case c == '/':
+			b.WriteByte('-')
+		case strings.IndexByte(escapeValidChars, c) == -1:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// Unescape reverses Escape. "-" is replaced by "/" and all "\x2d"
+// style escape sequences are replaced by the character they
+// represent.
+func Unescape(s string) (string, error) {
+	var b strings.Builder
+
+	for idx := 0; idx < len(s); idx++ {
+		c := s[idx]
+
+		switch c {
+		case '-':
+			b.WriteByte('/')
+		case '\\':
+			if idx+3 >= len(s) || s[idx+1] != 'x' {
+				return "", fmt.Errorf("invalid escape sequence at position %d in %q", idx, s)
+			}
+
+			x, err := strconv.ParseUint(s[idx+2:idx+4], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape sequence at position %d in %q", idx, s)
+			}
+			b.WriteByte(byte(x))
+			idx += 3
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String(), nil
+}
+
+// EscapePath escapes the path p like systemd-escape --path. The path
+// is simplified before being escaped (duplicate and trailing slashes
+// are removed) and the leading slash is dropped. The root directory
+// is escaped as "-". Paths containing ".." components are rejected.
+func EscapePath(p string) (string, error) {
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("path %q is not normalized", p)
+		}
+	}
+
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return "-", nil
+	}
+
+	return Escape(p), nil
+}
+
+// UnescapePath reverses EscapePath and returns an absolute path.
+func UnescapePath(s string) (string, error) {
+	if s == "" {
+		return "", fmt.Errorf("cannot unescape empty path")
+	}
+
+	if s == "-" {
+		return "/", nil
+	}
+
+	p, err := Unescape(s)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Clean("/" + p), nil
+}
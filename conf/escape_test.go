@@ -0,0 +1,70 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscape(t *testing.T) {
+	cases := []struct {
+		I string
+		O string
+	}{
+		{"", ""},
+		{"foo", "foo"},
+		{"foo/bar baz", "foo-bar\\x20baz"},
+		{"-foo-", "\\x2dfoo\\x2d"},
+		{".hidden", "\\x2ehidden"},
+		{"a.b:c_d", "a.b:c_d"},
+		{"back\\slash", "back\\x5cslash"},
+		{"äö", "\\xc3\\xa4\\xc3\\xb6"},
+	}
+
+	for idx, c := range cases {
+		assert.Equal(t, c.O, Escape(c.I), "case #%d", idx)
+
+		res, err := Unescape(c.O)
+		assert.NoError(t, err, "case #%d", idx)
+		assert.Equal(t, c.I, res, "case #%d", idx)
+	}
+}
+
+func TestUnescapeInvalid(t *testing.T) {
+	for _, c := range []string{"\\", "\\x", "\\x2", "\\y20", "\\xzz"} {
+		_, err := Unescape(c)
+		assert.Error(t, err, c)
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	cases := []struct {
+		I string
+		O string
+		U string
+	}{
+		{"/", "-", "/"},
+		{"/dev/sda", "dev-sda", "/dev/sda"},
+		{"/foo//bar/", "foo-bar", "/foo/bar"},
+		{"foo/./bar", "foo-bar", "/foo/bar"},
+		{"/mnt/.hidden", "mnt-.hidden", "/mnt/.hidden"},
+		{"/.hidden", "\\x2ehidden", "/.hidden"},
+		{"/srv/my web", "srv-my\\x20web", "/srv/my web"},
+	}
+
+	for idx, c := range cases {
+		res, err := EscapePath(c.I)
+		assert.NoError(t, err, "case #%d", idx)
+		assert.Equal(t, c.O, res, "case #%d", idx)
+
+		res, err = UnescapePath(c.O)
+		assert.NoError(t, err, "case #%d", idx)
+		assert.Equal(t, c.U, res, "case #%d", idx)
+	}
+
+	_, err := EscapePath("/foo/../bar")
+	assert.Error(t, err)
+
+	_, err = UnescapePath("")
+	assert.Error(t, err)
+}
@@ -3,7 +3,7 @@ package conf
 import (
 	"fmt"
 	"io"
-	"os"
+	"io/fs"
 	"strings"
 )
 
@@ -48,7 +48,12 @@ func (spec FileSpec) Parse(path string, r io.Reader, target interface{}) error {
 
 // ParseFile is like Parse but opens the file at path.
 func (spec FileSpec) ParseFile(path string, target interface{}) error {
-	f, err := os.Open(path)
+	return spec.ParseFileFS(osFS{}, path, target)
+}
+
+// ParseFileFS is like ParseFile but opens the file at path from fsys.
+func (spec FileSpec) ParseFileFS(fsys fs.FS, path string, target interface{}) error {
+	f, err := fsys.Open(path)
 	if err != nil {
 		return fmt.Errorf("failed to open: %w", err)
 	}
@@ -0,0 +1,162 @@
+package conf
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// FlagLayer collects option values set via command line flags
+// that have been registered using BindFlags.
+type FlagLayer struct {
+	fs       *flag.FlagSet
+	file     File
+	internal map[string]bool
+}
+
+// BindFlags registers a command line flag on fs for each option
+// defined in spec. Flags are named --section.option (lower-cased) and
+// use the option description as the help text. Slice options may be
+// specified multiple times while an empty value resets all values
+// specified so far. Values are validated against the option type when
+// the flag is set. Options marked as Internal are still accepted but
+// hidden from the help output. For that, BindFlags replaces fs.Usage.
+// Since flags cannot address a specific section, options of sections
+// that are defined multiple times always apply to the first one.
+//
+// Once fs has been parsed, use FlagLayer.File to get the values that
+// have been set. The result can be applied on top of other configuration
+// layers using OverlayFile.
+func BindFlags(fs *flag.FlagSet, spec FileSpec) *FlagLayer {
+	fl := &FlagLayer{
+		fs:       fs,
+		internal: make(map[string]bool),
+	}
+
+	for secName, optReg := range spec {
+		if optReg == nil {
+			continue
+		}
+
+		for _, optSpec := range optReg.All() {
+			name := strings.ToLower(secName + "." + optSpec.Name)
+			if optSpec.Internal {
+				fl.internal[name] = true
+			}
+
+			fs.Var(&optionFlag{
+				layer:   fl,
+				section: secName,
+				spec:    optSpec,
+			}, name, optSpec.Description)
+		}
+	}
+
+	fs.Usage = func() {
+		if fs.Name() == "" {
+			fmt.Fprintf(fs.Output(), "Usage:\n")
+		} else {
+			fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		}
+		fl.PrintDefaults()
+	}
+
+	return fl
+}
+
+// File returns all options that have been set using command line flags.
+// Sections are returned in the order they have been first used on the
+// command line.
+func (fl *FlagLayer) File() *File {
+	return fl.file.Clone()
+}
+
+// PrintDefaults is like flag.PrintDefaults but omits all flags for
+// internal options.
+func (fl *FlagLayer) PrintDefaults() {
+	visible := flag.NewFlagSet(fl.fs.Name(), flag.ContinueOnError)
+	visible.SetOutput(fl.fs.Output())
+
+	fl.fs.VisitAll(func(f *flag.Flag) {
+		if fl.internal[f.Name] {
+			return
+		}
+
+		visible.Var(f.Value, f.Name, f.Usage)
+		visible.Lookup(f.Name).DefValue = f.DefValue
+	})
+
+	visible.PrintDefaults()
+}
+
+// section returns the section with name from the layer file and
+// creates it if required.
+func (fl *FlagLayer) section(name string) *Section {
+	if sec := fl.file.Get(name); sec != nil {
+		return sec
+	}
+
+	fl.file.Sections = append(fl.file.Sections, Section{Name: name})
+	return &fl.file.Sections[len(fl.file.Sections)-1]
+}
+
+// optionFlag implements flag.Value for a single option.
+type optionFlag struct {
+	layer   *FlagLayer
+	section string
+	spec    OptionSpec
+}
+
+func (of *optionFlag) String() string {
+	if of == nil || of.layer == nil {
+		return ""
+	}
+
+	sec := of.layer.file.Get(of.section)
+	if sec == nil {
+		return of.spec.Default
+	}
+
+	values := sec.GetStringSlice(of.spec.Name)
+	if len(values) == 0 {
+		return of.spec.Default
+	}
+
+	return strings.Join(values, ",")
+}
+
+func (of *optionFlag) Set(value string) error {
+	isSlice := of.spec.Type.IsSliceType()
+
+	// an empty value resets slice options
+	if !isSlice || value != "" {
+		if err := ValidateValue(value, of.spec.Type); err != nil {
+			return err
+		}
+	}
+
+	sec := of.layer.section(of.section)
+
+	if !isSlice || value == "" {
+		// remove all values set so far.
+		var opts Options
+		for _, opt := range sec.Options {
+			if !strings.EqualFold(opt.Name, of.spec.Name) {
+				opts = append(opts, opt)
+			}
+		}
+		sec.Options = opts
+	}
+
+	sec.Options = append(sec.Options, Option{
+		Name:  of.spec.Name,
+		Value: value,
+	})
+
+	return nil
+}
+
+// IsBoolFlag allows to use boolean options without a value.
+func (of *optionFlag) IsBoolFlag() bool {
+	return of.spec.Type == BoolType
+}
@@ -0,0 +1,116 @@
+package conf_test
+
+import (
+	"bytes"
+	"flag"
+	"testing"
+
+	"github.com/ppacher/system-conf/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBindFlags(t *testing.T) {
+	spec := conf.FileSpec{
+		"Global": conf.SectionSpec{
+			{Name: "LogLevel", Type: conf.StringType, Description: "The log level", Default: "info"},
+			{Name: "Tags", Type: conf.StringSliceType, Description: "Tags to add"},
+			{Name: "Debug", Type: conf.BoolType, Description: "Enable debugging"},
+			{Name: "Secret", Type: conf.StringType, Description: "Internal option", Internal: true},
+		},
+		"Listener": conf.SectionSpec{
+			{Name: "Port", Type: conf.IntType, Description: "Port to listen on"},
+		},
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	layer := conf.BindFlags(fs, spec)
+
+	err := fs.Parse([]string{
+		"--listener.port=8080",
+		"--global.tags=a",
+		"--global.tags=b",
+		"--global.debug",
+		"--global.loglevel=debug",
+		"--global.loglevel=warn",
+		"--global.secret=s3cr3t",
+		"remaining",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"remaining"}, fs.Args())
+
+	assert.Equal(t, &conf.File{
+		Sections: conf.Sections{
+			{
+				Name: "Listener",
+				Options: conf.Options{
+					{Name: "Port", Value: "8080"},
+				},
+			},
+			{
+				Name: "Global",
+				Options: conf.Options{
+					{Name: "Tags", Value: "a"},
+					{Name: "Tags", Value: "b"},
+					{Name: "Debug", Value: "true"},
+					{Name: "LogLevel", Value: "warn"},
+					{Name: "Secret", Value: "s3cr3t"},
+				},
+			},
+		},
+	}, layer.File())
+
+	// invalid values are rejected
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(new(bytes.Buffer))
+	conf.BindFlags(fs, spec)
+	assert.Error(t, fs.Parse([]string{"--listener.port=abc"}))
+}
+
+func TestBindFlagsOverlay(t *testing.T) {
+	spec := conf.FileSpec{
+		"Global": conf.SectionSpec{
+			{Name: "Tags", Type: conf.StringSliceType},
+			{Name: "Name", Type: conf.StringType},
+		},
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	layer := conf.BindFlags(fs, spec)
+	assert.NoError(t, fs.Parse([]string{"--global.tags=a", "--global.tags=", "--global.tags=c"}))
+
+	f := &conf.File{
+		Sections: conf.Sections{
+			{
+				Name: "Global",
+				Options: conf.Options{
+					{Name: "Name", Value: "file"},
+					{Name: "Tags", Value: "from-file"},
+				},
+			},
+		},
+	}
+
+	assert.NoError(t, conf.OverlayFile(f, layer.File(), spec))
+	assert.Equal(t, []string{"c"}, f.Get("global").GetStringSlice("tags"))
+	assert.Equal(t, []string{"file"}, f.Get("global").GetStringSlice("name"))
+}
+
+func TestBindFlagsUsage(t *testing.T) {
+	spec := conf.FileSpec{
+		"Global": conf.SectionSpec{
+			{Name: "LogLevel", Type: conf.StringType, Description: "The log level", Default: "info"},
+			{Name: "Secret", Type: conf.StringType, Description: "Internal option", Internal: true},
+		},
+	}
+
+	out := new(bytes.Buffer)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(out)
+	conf.BindFlags(fs, spec)
+
+	fs.Usage()
+	assert.Contains(t, out.String(), "Usage of test:")
+	assert.Contains(t, out.String(), "-global.loglevel")
+	assert.Contains(t, out.String(), "The log level (default info)")
+	assert.NotContains(t, out.String(), "global.secret")
+}
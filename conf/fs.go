@@ -0,0 +1,166 @@
+package conf
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// osFS implements fs.FS using the os package. Unlike os.DirFS
+// it is not rooted so names are passed to the os package as they
+// are. It's used by all loading functions that don't accept an fs.FS
+// so existing absolute and relative paths keep working.
+type osFS struct{}
+
+// Open implements fs.FS.
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(filepath.FromSlash(name))
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(filepath.FromSlash(name))
+}
+
+// Stat implements fs.StatFS.
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(filepath.FromSlash(name))
+}
+
+// LayeredFS combines multiple file systems into one. Layers are
+// ordered by priority with lowest-priority first, the same as search
+// paths. A file found in a latter layer shadows a file with the same
+// name in a previous layer while the entries of directories that exist
+// in multiple layers are merged. This allows to ship default units
+// using embed.FS and let on-disk files override them:
+//
+//	fsys := conf.LayeredFS{defaults, os.DirFS("/etc/myapp")}
+//
+// LayeredFS implements fs.ReadDirFS and fs.StatFS.
+type LayeredFS []fs.FS
+
+// Open implements fs.FS.
+func (lfs LayeredFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	for idx := len(lfs) - 1; idx >= 0; idx-- {
+		f, err := lfs[idx].Open(name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if !stat.IsDir() {
+			return f, nil
+		}
+
+		// directories are merged so reading them must return
+		// the same entries as ReadDir.
+		entries, err := lfs.ReadDir(name)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		return &layeredDir{File: f, entries: entries}, nil
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// Stat implements fs.StatFS.
+func (lfs LayeredFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	for idx := len(lfs) - 1; idx >= 0; idx-- {
+		stat, err := fs.Stat(lfs[idx], name)
+		if err == nil {
+			return stat, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir implements fs.ReadDirFS. It returns the merged entries
+// of name in all layers sorted by file name.
+func (lfs LayeredFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entries := make(map[string]fs.DirEntry)
+	found := false
+
+	for _, layer := range lfs {
+		layerEntries, err := fs.ReadDir(layer, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+
+		for _, e := range layerEntries {
+			entries[e.Name()] = e
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	result := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+
+	return result, nil
+}
+
+// layeredDir is returned by LayeredFS.Open for directories.
+type layeredDir struct {
+	fs.File
+	entries []fs.DirEntry
+	offset  int
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *layeredDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+
+	return rest[:n], nil
+}
@@ -0,0 +1,53 @@
+package conf
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLayeredFS(t *testing.T) {
+	lfs := LayeredFS{
+		fstest.MapFS{
+			"units/a.unit":        {Data: []byte("[Test]\nName=embedded-a\n")},
+			"units/b.unit":        {Data: []byte("[Test]\nName=embedded-b\n")},
+			"units/b.unit.d/x.md": {Data: []byte("docs")},
+		},
+		fstest.MapFS{
+			"units/b.unit": {Data: []byte("[Test]\nName=disk-b\n")},
+			"units/c.unit": {Data: []byte("[Test]\nName=disk-c\n")},
+			"other/d.unit": {Data: []byte("[Test]\nName=disk-d\n")},
+		},
+	}
+
+	assert.NoError(t, fstest.TestFS(lfs,
+		"units/a.unit",
+		"units/b.unit",
+		"units/c.unit",
+		"units/b.unit.d/x.md",
+		"other/d.unit",
+	))
+
+	spec := FileSpec{
+		"test": SectionSpec{
+			{Name: "Name", Type: StringType},
+		},
+	}
+
+	files, err := ReadDirFS(lfs, "units", ".unit", spec)
+	assert.NoError(t, err)
+
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Path+"="+f.Sections[0].Options[0].Value)
+	}
+	assert.Equal(t, []string{
+		"units/a.unit=embedded-a",
+		"units/b.unit=disk-b",
+		"units/c.unit=disk-c",
+	}, names)
+
+	_, err = LoadFileFS(lfs, "units/missing.unit")
+	assert.Error(t, err)
+}
@@ -0,0 +1,31 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// NewTestDir creates a temporary directory that is removed once
+// the test finishes. The returned write function creates the file
+// at the slash separated path relative to the directory, including
+// all parent directories, and returns its full path. It is exported
+// so tests in package conf_test can use it as well.
+func NewTestDir(t *testing.T) (string, func(path, content string) string) {
+	t.Helper()
+
+	root := t.TempDir()
+	write := func(path, content string) string {
+		t.Helper()
+
+		path = filepath.Join(root, filepath.FromSlash(path))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	return root, write
+}
@@ -0,0 +1,186 @@
+package conf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadFileIncludes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"units/app.unit":        {Data: []byte(".include common/net.conf\n[Service]\nExec=app\n.include log.conf\nUser=app\n")},
+		"units/common/net.conf": {Data: []byte("[Network]\nAddress=127.0.0.1\n")},
+		"shared/log.conf":       {Data: []byte("[Log]\nLevel=debug\n.include level.conf\n")},
+		"shared/level.conf":     {Data: []byte("[Level]\nName=debug\n")},
+	}
+
+	// includes are disabled by default.
+	_, err := LoadFileContext(context.Background(), fsys, "units/app.unit")
+	assert.Error(t, err)
+
+	cfg := ParseConfig{Includes: true, IncludePath: []string{"shared"}}
+	f, err := LoadFileContext(context.Background(), fsys, "units/app.unit", cfg)
+	require.NoError(t, err)
+
+	var names []string
+	for _, sec := range f.Sections {
+		names = append(names, sec.Name+"@"+sec.Source)
+	}
+	assert.Equal(t, []string{
+		"Network@units/common/net.conf",
+		"Service@",
+		"Log@shared/log.conf",
+		"Level@shared/level.conf",
+	}, names)
+
+	// options after an include directive still belong
+	// to the including section.
+	assert.Equal(t, []string{"app"}, f.Sections[1].GetStringSlice("User"))
+	assert.Equal(t, 1, f.Sections[2].Line)
+}
+
+func TestLoadFileIncludeBeforeSection(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.unit": {Data: []byte("# header\n.include a.conf\nExec=ignored .include b.conf\n.include b.conf\n[Service]\nExec=app\n")},
+		"a.conf":   {Data: []byte("[A]\nKey=a\n")},
+		"b.conf":   {Data: []byte("[B]\nKey=b\n")},
+	}
+
+	// included sections are added before the first section while
+	// all other content before the first section is ignored.
+	f, err := LoadFileContext(context.Background(), fsys, "app.unit", ParseConfig{Includes: true})
+	require.NoError(t, err)
+	assert.Equal(t, Sections{
+		{Name: "A", Line: 1, Source: "a.conf", OptionLines: map[string][]int{"key": {2}}, Options: Options{{Name: "Key", Value: "a"}}},
+		{Name: "B", Line: 1, Source: "b.conf", OptionLines: map[string][]int{"key": {2}}, Options: Options{{Name: "Key", Value: "b"}}},
+		{Name: "Service", Line: 5, OptionLines: map[string][]int{"exec": {6}}, Options: Options{{Name: "Exec", Value: "app"}}},
+	}, f.Sections)
+}
+
+func TestLoadFileIncludeErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.conf":       {Data: []byte("[A]\n.include b.conf\n")},
+		"b.conf":       {Data: []byte("[B]\n.include a.conf\n")},
+		"missing.conf": {Data: []byte("[A]\nKey=value\n.include does-not-exist.conf\n")},
+		"broken.conf":  {Data: []byte("[A]\n.include garbage.conf\n")},
+		"garbage.conf": {Data: []byte("[B]\nKey=value\n[C] garbage\n")},
+	}
+	cfg := ParseConfig{Includes: true}
+
+	_, err := LoadFileContext(context.Background(), fsys, "a.conf", cfg)
+	assert.True(t, errors.Is(err, ErrIncludeCycle), "%v", err)
+
+	_, err = LoadFileContext(context.Background(), fsys, "missing.conf", cfg)
+	var pe *ParseError
+	if assert.True(t, errors.As(err, &pe)) {
+		assert.Equal(t, 3, pe.Line)
+		assert.Empty(t, pe.Source)
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+	}
+
+	// errors in included files point into the included file.
+	_, err = LoadFileContext(context.Background(), fsys, "broken.conf", cfg)
+	if assert.True(t, errors.As(err, &pe)) {
+		assert.Equal(t, 3, pe.Line)
+		assert.Equal(t, "garbage.conf", pe.Source)
+		assert.True(t, strings.HasPrefix(err.Error(), "garbage.conf: line 3: "), err.Error())
+	}
+}
+
+func TestLoadFileIncludeNotAllowed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"units/app.unit":      {Data: []byte("[A]\n.include ../secret.conf\n")},
+		"units/abs.unit":      {Data: []byte("[A]\n.include /secret.conf\n")},
+		"units/sub.unit":      {Data: []byte("[A]\n.include common/../common/b.conf\n")},
+		"units/common/b.conf": {Data: []byte("[B]\n")},
+		"units/common/c.conf": {Data: []byte("[C]\n.include ../../secret.conf\n")},
+		"units/search.unit":   {Data: []byte("[A]\n.include c.conf\n")},
+		"secret.conf":         {Data: []byte("[Secret]\n")},
+		"shared/secret.conf":  {Data: []byte("[Secret]\n")},
+	}
+	cfg := ParseConfig{Includes: true, IncludePath: []string{"shared/sub", "units/common"}}
+
+	// included files must not leave the directory of the
+	// including file or the include path.
+	for _, name := range []string{"units/app.unit", "units/abs.unit", "units/search.unit"} {
+		_, err := LoadFileContext(context.Background(), fsys, name, cfg)
+		assert.True(t, errors.Is(err, ErrIncludeNotAllowed), "%s: %v", name, err)
+	}
+
+	f, err := LoadFileContext(context.Background(), fsys, "units/sub.unit", cfg)
+	if assert.NoError(t, err) {
+		assert.Len(t, f.Sections, 2)
+	}
+
+	// DeserializeContext does not know where to load included
+	// files from unless IncludeFS is set.
+	content := "[A]\n.include b.conf\n"
+	_, err = DeserializeContext(context.Background(), "", strings.NewReader(content), cfg)
+	assert.True(t, errors.Is(err, ErrIncludeNotAllowed), "%v", err)
+
+	cfg.IncludeFS = fsys
+	f, err = DeserializeContext(context.Background(), "", strings.NewReader(content), cfg)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "units/common/b.conf", f.Sections[1].Source)
+	}
+}
+
+func TestLoadFileIncludeLimit(t *testing.T) {
+	// each file includes the next one twice so the number
+	// of sections doubles with each level.
+	fsys := fstest.MapFS{}
+	for idx := 0; idx < 12; idx++ {
+		content := fmt.Sprintf("[Level%d]\n", idx)
+		if idx < 11 {
+			next := fmt.Sprintf(".include %d.conf\n", idx+1)
+			content += next + next
+		}
+		fsys[fmt.Sprintf("%d.conf", idx)] = &fstest.MapFile{Data: []byte(content)}
+	}
+
+	_, err := LoadFileContext(context.Background(), fsys, "0.conf", ParseConfig{Includes: true})
+	assert.True(t, errors.Is(err, ErrLimitExceeded), "%v", err)
+	assert.Contains(t, err.Error(), fmt.Sprintf("more than %d includes", DefaultMaxIncludes))
+
+	f, err := LoadFileContext(context.Background(), fsys, "9.conf", ParseConfig{Includes: true, MaxIncludes: 6})
+	if assert.NoError(t, err) {
+		assert.Len(t, f.Sections, 7)
+	}
+
+	_, err = LoadFileContext(context.Background(), fsys, "9.conf", ParseConfig{Includes: true, MaxIncludes: 5})
+	assert.True(t, errors.Is(err, ErrLimitExceeded), "%v", err)
+}
+
+func TestReadDirIncludeValidationError(t *testing.T) {
+	fsys := fstest.MapFS{
+		"units/app.unit":   {Data: []byte("[Service]\nExec=app\n.include common.conf\n")},
+		"common.conf":      {Data: []byte("# shared settings\n[Service]\nUnknown=1\n")},
+		"units/other.unit": {Data: []byte("[Service]\nExec=other\n")},
+	}
+	spec := FileSpec{
+		"service": SectionSpec{
+			{Name: "Exec", Type: StringType},
+		},
+	}
+
+	files, err := ReadDirFS(fsys, "units", ".unit", spec, ReadDirConfig{
+		ContinueOnError: true,
+		Parse:           ParseConfig{Includes: true, IncludePath: []string{"."}},
+	})
+	assert.Len(t, files, 1)
+
+	var errs FileErrors
+	if assert.True(t, errors.As(err, &errs)) && assert.Len(t, errs, 1) {
+		assert.Equal(t, "common.conf", errs[0].Path)
+		assert.Equal(t, ValidationFailure, errs[0].Kind)
+		assert.Equal(t, 3, errs[0].Line)
+		assert.Equal(t, "Unknown", errs[0].Option)
+	}
+}
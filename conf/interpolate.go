@@ -0,0 +1,258 @@
+package conf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InterpolationConfig configures variable interpolation performed
+// by Interpolate.
+type InterpolationConfig struct {
+	// Env holds environment variables in the form KEY=VALUE
+	// (see os.Environ()) that may be referenced by ${KEY}.
+	Env []string
+
+	// Strict may be set to true to return an error if a value
+	// references an undefined option or environment variable.
+	// If false, undefined references are kept as they are.
+	Strict bool
+}
+
+// InterpolationError is returned by Interpolate if a reference
+// cannot be resolved.
+type InterpolationError struct {
+	// Section is the name of the section that contains the option.
+	Section string
+
+	// Option is the name of the option that contains the reference.
+	Option string
+
+	// Reference is the reference that failed (like ${Global.Root}).
+	Reference string
+
+	// Value is the raw value of the option. It is masked if the
+	// option is marked as a secret (see IsSecret).
+	Value string
+
+	// Err is the error that occurred.
+	Err error
+}
+
+func (ie *InterpolationError) Error() string {
+	return fmt.Sprintf("%s.%s: %s in %q: %s", ie.Section, ie.Option, ie.Reference, ie.Value, ie.Err)
+}
+
+// Unwrap returns the underlying error.
+func (ie *InterpolationError) Unwrap() error {
+	return ie.Err
+}
+
+// secretMask is used instead of the actual value of secret
+// options in error messages.
+const secretMask = "<secret>"
+
+// Interpolate resolves all variable references in option values of f
+// and returns the result as a new file. The original File f remains
+// untouched. A value may reference the value of another option using
+// ${Section.Option} or an environment variable from cfg.Env using
+// ${NAME}. Section and option names are compared using equal fold and
+// references to a section that is defined multiple times always use the
+// first one. Sections with an argument (see Section.Arg) are referenced
+// using their header, like ${Remote "origin".URL}, while a reference
+// without an argument only matches sections without one. If a referenced
+// option has multiple values they are joined using a single space. Use
+// $${ to insert a literal ${.
+//
+// Interpolate should be called after drop-ins have been applied and
+// before the file is validated. reg is used to find options marked as
+// secret so their values are never included in error messages. reg
+// may be nil.
+func Interpolate(f *File, reg SectionRegistry, cfg InterpolationConfig) (*File, error) {
+	ip := &interpolator{
+		file:     f.Clone(),
+		reg:      reg,
+		strict:   cfg.Strict,
+		resolved: make(map[string]string),
+		visiting: make(map[string]bool),
+	}
+	if len(cfg.Env) > 0 {
+		_, ip.env = toMap(cfg.Env)
+	}
+
+	// resolve all values using the original file so values are not
+	// interpolated twice.
+	result := f.Clone()
+	for secIdx, sec := range result.Sections {
+		for optIdx, opt := range sec.Options {
+			val, err := ip.expand(sec.Name, opt.Name, opt.Value)
+			if err != nil {
+				return nil, err
+			}
+			result.Sections[secIdx].Options[optIdx].Value = val
+		}
+	}
+
+	return result, nil
+}
+
+type interpolator struct {
+	file     *File
+	reg      SectionRegistry
+	env      map[string]string
+	strict   bool
+	resolved map[string]string
+	visiting map[string]bool
+}
+
+// expand resolves all references in value that is set for
+// secName.optName.
+func (ip *interpolator) expand(secName, optName, value string) (string, error) {
+	var b strings.Builder
+	raw := value
+
+	for {
+		idx := strings.Index(value, "${")
+		if idx == -1 {
+			b.WriteString(value)
+			break
+		}
+
+		// $${ is an escaped ${
+		if idx > 0 && value[idx-1] == '$' {
+			b.WriteString(value[:idx-1])
+			b.WriteString("${")
+			value = value[idx+2:]
+			continue
+		}
+
+		end := strings.IndexByte(value[idx:], '}')
+		if end == -1 {
+			// no closing brace so there's nothing to replace.
+			b.WriteString(value)
+			break
+		}
+		end += idx
+
+		b.WriteString(value[:idx])
+		ref := value[idx : end+1]
+		value = value[end+1:]
+
+		val, ok, err := ip.lookup(ref[2 : len(ref)-1])
+		if err == nil && !ok && ip.strict {
+			err = ErrUndefinedReference
+		}
+		if err != nil {
+			if ie, ok := err.(*InterpolationError); ok {
+				return "", ie
+			}
+			return "", &InterpolationError{
+				Section:   secName,
+				Option:    optName,
+				Reference: ref,
+				Value:     ip.displayValue(secName, optName, raw),
+				Err:       err,
+			}
+		}
+
+		if !ok {
+			b.WriteString(ref)
+			continue
+		}
+
+		b.WriteString(val)
+	}
+
+	return b.String(), nil
+}
+
+// lookup resolves the reference name which is either
+// Section.Option or the name of an environment variable.
+func (ip *interpolator) lookup(name string) (string, bool, error) {
+	idx := strings.LastIndex(name, ".")
+	if idx == -1 {
+		val, ok := ip.env[name]
+		return val, ok, nil
+	}
+
+	secName := name[:idx]
+	optName := name[idx+1:]
+
+	sec := ip.findSection(secName)
+	if sec == nil {
+		return "", false, nil
+	}
+
+	sk := keyOfSection(*sec)
+	key := sk.name + "\x00" + sk.arg + "\x00" + strings.ToLower(optName)
+	if val, ok := ip.resolved[key]; ok {
+		return val, true, nil
+	}
+
+	values := sec.GetStringSlice(optName)
+	if len(values) == 0 {
+		return "", false, nil
+	}
+
+	if ip.visiting[key] {
+		return "", false, ErrReferenceCycle
+	}
+	ip.visiting[key] = true
+	defer delete(ip.visiting, key)
+
+	for idx, val := range values {
+		var err error
+		values[idx], err = ip.expand(sec.Name, optName, val)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	val := strings.Join(values, " ")
+	ip.resolved[key] = val
+
+	return val, true, nil
+}
+
+// findSection returns the first section referenced by header. The
+// header may hold the section name and argument, like Remote "origin",
+// or just the name of a section without an argument. It returns nil if
+// no section matches.
+func (ip *interpolator) findSection(header string) *Section {
+	name, arg, err := parseSectionHeader(header)
+	if err != nil {
+		name, arg = header, ""
+	}
+
+	for idx, sec := range ip.file.Sections {
+		if sec.Arg == arg && strings.EqualFold(sec.Name, name) {
+			return &ip.file.Sections[idx]
+		}
+
+		// sections parsed without ParseConfig.SectionArgs may
+		// have whitespace in their name.
+		if sec.Arg == "" && strings.EqualFold(sec.Name, header) {
+			return &ip.file.Sections[idx]
+		}
+	}
+
+	return nil
+}
+
+// displayValue returns value for use in error messages. If
+// secName.optName is marked as a secret the value is masked.
+func (ip *interpolator) displayValue(secName, optName, value string) string {
+	if ip.reg == nil {
+		return value
+	}
+
+	optReg, ok := ip.reg.OptionsForSection(strings.ToLower(secName))
+	if !ok || optReg == nil {
+		return value
+	}
+
+	if spec, ok := optReg.GetOption(strings.ToLower(optName)); ok && IsSecret(spec) {
+		return secretMask
+	}
+
+	return value
+}
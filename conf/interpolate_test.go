@@ -0,0 +1,111 @@
+package conf_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ppacher/system-conf/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpolate(t *testing.T) {
+	f := &conf.File{
+		Sections: conf.Sections{
+			{
+				Name: "Global",
+				Options: conf.Options{
+					{Name: "Root", Value: "${HOME}/app"},
+					{Name: "Fields", Value: "a"},
+					{Name: "Fields", Value: "b"},
+				},
+			},
+			{
+				Name: "Storage",
+				Options: conf.Options{
+					{Name: "DataDir", Value: "${global.root}/data"},
+					{Name: "CacheDir", Value: "${Storage.DataDir}/cache"},
+					{Name: "Literal", Value: "$${Global.Root} ${Global.Fields}"},
+					{Name: "Unknown", Value: "${UNDEFINED} ${Global.Missing}"},
+				},
+			},
+		},
+	}
+
+	res, err := conf.Interpolate(f, nil, conf.InterpolationConfig{
+		Env: []string{"HOME=/home/alice"},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"/home/alice/app"}, res.Get("Global").GetStringSlice("Root"))
+	assert.Equal(t, []string{"/home/alice/app/data"}, res.Get("Storage").GetStringSlice("DataDir"))
+	assert.Equal(t, []string{"/home/alice/app/data/cache"}, res.Get("Storage").GetStringSlice("CacheDir"))
+	assert.Equal(t, []string{"${Global.Root} a b"}, res.Get("Storage").GetStringSlice("Literal"))
+	assert.Equal(t, []string{"${UNDEFINED} ${Global.Missing}"}, res.Get("Storage").GetStringSlice("Unknown"))
+
+	// the original file must not be modified
+	assert.Equal(t, []string{"${global.root}/data"}, f.Get("Storage").GetStringSlice("DataDir"))
+
+	_, err = conf.Interpolate(f, nil, conf.InterpolationConfig{
+		Env:    []string{"HOME=/home/alice"},
+		Strict: true,
+	})
+	assert.True(t, errors.Is(err, conf.ErrUndefinedReference))
+}
+
+func TestInterpolateCycle(t *testing.T) {
+	f := &conf.File{
+		Sections: conf.Sections{
+			{
+				Name: "A",
+				Options: conf.Options{
+					{Name: "One", Value: "${A.Two}"},
+					{Name: "Two", Value: "${A.One}"},
+				},
+			},
+		},
+	}
+
+	_, err := conf.Interpolate(f, nil, conf.InterpolationConfig{})
+	assert.True(t, errors.Is(err, conf.ErrReferenceCycle))
+}
+
+func TestInterpolateSecret(t *testing.T) {
+	spec := conf.FileSpec{
+		"auth": conf.SectionSpec{
+			{
+				Name:        "Password",
+				Type:        conf.StringType,
+				Annotations: new(conf.Annotation).With(conf.SecretValue()),
+			},
+			{
+				Name: "User",
+				Type: conf.StringType,
+			},
+		},
+	}
+
+	f := &conf.File{
+		Sections: conf.Sections{
+			{
+				Name: "Auth",
+				Options: conf.Options{
+					{Name: "Password", Value: "s3cr3t-${MISSING}"},
+				},
+			},
+		},
+	}
+
+	_, err := conf.Interpolate(f, spec, conf.InterpolationConfig{Strict: true})
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "s3cr3t")
+		assert.Contains(t, err.Error(), "${MISSING}")
+	}
+
+	f.Sections[0].Options = conf.Options{
+		{Name: "Password", Value: "s3cr3t"},
+		{Name: "User", Value: "admin-${Auth.Password}"},
+	}
+	res, err := conf.Interpolate(f, spec, conf.InterpolationConfig{Strict: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin-s3cr3t"}, res.Get("Auth").GetStringSlice("User"))
+}
@@ -20,10 +20,11 @@ package conf
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"io/fs"
 	"strings"
 	"unicode"
 )
@@ -43,8 +44,10 @@ const (
 )
 
 var (
-	// ErrLineTooLong gets returned when a line is too long for systemd to handle.
-	ErrLineTooLong = fmt.Errorf("line too long (max %d bytes)", SystemdLineMax)
+	// ErrLineTooLong gets returned when a line is longer than allowed by
+	// ParseConfig.MaxLineLength (SystemdLineMax by default). It is
+	// returned as it is and not wrapped in a *ParseError.
+	ErrLineTooLong = errors.New("line too long")
 )
 
 type (
@@ -62,9 +65,47 @@ type (
 	Section struct {
 		Name string
 
+		// Arg is the argument of the section header if
+		// ParseConfig.SectionArgs is set. For example, the header
+		// [Remote "origin"] has the name Remote and the argument
+		// origin. Arg is empty for sections without an argument.
+		Arg string
+
+		// Line is the line number of the section header in the file
+		// it has been parsed from. It is zero if unknown.
+		Line int
+
+		// Source is the path of the file the section has been parsed
+		// from if it has been included from another file (see
+		// ParseConfig.Includes). It is empty otherwise.
+		Source string
+
+		// OptionLines holds the line numbers of all option values
+		// in the file the section has been parsed from. It is keyed
+		// by the lower-case option name and holds one line number
+		// for each value in the order they appear in Options. Use
+		// OptionLine to look up a line number.
+		OptionLines map[string][]int
+
 		Options
 	}
 
+	// ParseError is returned by Deserialize and all functions
+	// that load files if the content cannot be parsed. Errors
+	// returned by the underlying reader and ErrLineTooLong are
+	// returned as they are.
+	ParseError struct {
+		// Source is the path of the included file that caused the
+		// error. It is empty if the error occurred in the file itself.
+		Source string
+
+		// Line is the line number where the error occurred.
+		Line int
+
+		// Err is the error that occurred.
+		Err error
+	}
+
 	// File is a configuration file.
 	File struct {
 		// Path holds the path to the unit file.
@@ -79,27 +120,18 @@ type (
 // The path parameter is only copied to the returned File struct and may be left
 // empty.
 func Deserialize(path string, f io.Reader) (*File, error) {
-	lexer, secchan, errchan := newLexer(f)
-	go lexer.lex()
-
-	var sections Sections
-	for sec := range secchan {
-		sections = append(sections, *sec)
-	}
-
-	err := <-errchan
-	return &File{Path: path, Sections: sections}, err
+	return DeserializeContext(context.Background(), path, f)
 }
 
 // LoadFile loads the unit file at path.
 func LoadFile(path string) (*File, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
+	return LoadFileFS(osFS{}, path)
+}
 
-	return Deserialize(path, f)
+// LoadFileFS is like LoadFile but loads the unit file at path
+// from fsys.
+func LoadFileFS(fsys fs.FS, path string) (*File, error) {
+	return LoadFileContext(context.Background(), fsys, path)
 }
 
 // Clone creates a deep copy of f.
@@ -111,16 +143,15 @@ func (f *File) Clone() *File {
 
 	for idx, sec := range f.Sections {
 		secCopy := Section{
-			Name:    sec.Name,
-			Options: make(Options, len(sec.Options)),
+			Name:        sec.Name,
+			Arg:         sec.Arg,
+			Line:        sec.Line,
+			Source:      sec.Source,
+			OptionLines: cloneOptionLines(sec.OptionLines),
+			Options:     make(Options, len(sec.Options)),
 		}
 
-		for optIndex, opt := range sec.Options {
-			secCopy.Options[optIndex] = Option{
-				Name:  opt.Name,
-				Value: opt.Value,
-			}
-		}
+		copy(secCopy.Options, sec.Options)
 
 		c.Sections[idx] = secCopy
 	}
@@ -128,19 +159,217 @@ func (f *File) Clone() *File {
 	return c
 }
 
-func newLexer(f io.Reader) (*lexer, <-chan *Section, <-chan error) {
+// OptionLine returns the line number of the n-th value of the option
+// name. It returns zero if the line number is unknown, for example,
+// because the value has been added by a drop-in or ApplyDefaults.
+func (sec Section) OptionLine(name string, n int) int {
+	lines := sec.OptionLines[strings.ToLower(name)]
+	if n < 0 || n >= len(lines) {
+		return 0
+	}
+	return lines[n]
+}
+
+// addOptionLine records line as the line number of the next
+// value of the option name.
+func (sec *Section) addOptionLine(name string, line int) {
+	if sec.OptionLines == nil {
+		sec.OptionLines = make(map[string][]int)
+	}
+	key := strings.ToLower(name)
+	sec.OptionLines[key] = append(sec.OptionLines[key], line)
+}
+
+// cloneOptionLines returns a deep copy of lines.
+func cloneOptionLines(lines map[string][]int) map[string][]int {
+	if lines == nil {
+		return nil
+	}
+
+	c := make(map[string][]int, len(lines))
+	for name, l := range lines {
+		c[name] = append([]int(nil), l...)
+	}
+	return c
+}
+
+func (pe *ParseError) Error() string {
+	if pe.Source != "" {
+		return fmt.Sprintf("%s: line %d: %s", pe.Source, pe.Line, pe.Err)
+	}
+	return fmt.Sprintf("line %d: %s", pe.Line, pe.Err)
+}
+
+// Unwrap returns the underlying error.
+func (pe *ParseError) Unwrap() error {
+	return pe.Err
+}
+
+// includeFunc loads the sections of the file referenced by
+// an include directive.
+type includeFunc func(name string) (Sections, error)
+
+func newLexer(ctx context.Context, f io.Reader, cfg ParseConfig, include includeFunc) (*lexer, <-chan *Section, <-chan error) {
 	secchan := make(chan *Section)
 	errchan := make(chan error, 1)
-	buf := bufio.NewReader(f)
 
-	return &lexer{buf, secchan, errchan, nil}, secchan, errchan
+	return &lexer{
+		ctx:           ctx,
+		cfg:           cfg,
+		include:       include,
+		buf:           bufio.NewReader(f),
+		secchan:       secchan,
+		errchan:       errchan,
+		line:          1,
+		maxLineLength: cfg.maxLineLength(),
+	}, secchan, errchan
 }
 
 type lexer struct {
+	ctx     context.Context
+	cfg     ParseConfig
 	buf     *bufio.Reader
 	secchan chan *Section
 	errchan chan error
 	section *Section
+
+	// sections is the number of sections found so far.
+	sections int
+
+	// include is called for each include directive. If nil,
+	// include directives are not supported.
+	include includeFunc
+	// included holds all included sections that are emitted
+	// once the current section is complete.
+	included Sections
+
+	// line is the line number of the next byte read from buf.
+	line int
+	// lineLength is the number of bytes read from the current line.
+	lineLength int
+	// prevLineLength is the length of the previous line. It's used
+	// to restore lineLength when a newline is unread.
+	prevLineLength int
+	// maxLineLength is the maximum length of a line or zero
+	// if unlimited.
+	maxLineLength int
+	// lastRune and lastSize describe the rune returned by the
+	// last call to readRune.
+	lastRune rune
+	lastSize int
+}
+
+// emit sends sec to the section channel followed by all sections
+// that have been included while sec was the current section.
+func (l *lexer) emit(sec *Section) error {
+	pending := append([]*Section{sec}, l.includedSections()...)
+	for _, s := range pending {
+		select {
+		case l.secchan <- s:
+		case <-l.ctx.Done():
+			return l.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// includedSections returns and clears all pending included
+// sections.
+func (l *lexer) includedSections() []*Section {
+	result := make([]*Section, len(l.included))
+	for idx := range l.included {
+		result[idx] = &l.included[idx]
+	}
+	l.included = nil
+	return result
+}
+
+// readRune reads a single rune from the buffer and keeps
+// track of the current line.
+func (l *lexer) readRune() (rune, error) {
+	r, size, err := l.buf.ReadRune()
+	if err != nil {
+		return r, err
+	}
+
+	l.lastRune = r
+	l.lastSize = size
+	if r == '\n' {
+		l.newLine()
+		return r, nil
+	}
+	return r, l.addLineLength(size)
+}
+
+// unreadRune unreads the last rune returned by readRune.
+func (l *lexer) unreadRune() error {
+	if err := l.buf.UnreadRune(); err != nil {
+		return err
+	}
+
+	if l.lastRune == '\n' {
+		l.line--
+		l.lineLength = l.prevLineLength
+	} else {
+		l.lineLength -= l.lastSize
+	}
+	return nil
+}
+
+// readBytes is like bufio.Reader.ReadBytes but keeps track of
+// the current line. It stops reading as soon as a line exceeds
+// the maximum line length so overly long lines are never buffered
+// completely.
+func (l *lexer) readBytes(delim byte) ([]byte, error) {
+	var result []byte
+	for {
+		chunk, err := l.buf.ReadSlice(delim)
+		if lengthErr := l.countLines(chunk); lengthErr != nil {
+			return nil, lengthErr
+		}
+		result = append(result, chunk...)
+
+		if err != bufio.ErrBufferFull {
+			return result, err
+		}
+	}
+}
+
+// countLines updates the line number and the length of the
+// current line for data read from buf.
+func (l *lexer) countLines(data []byte) error {
+	for {
+		idx := bytes.IndexByte(data, '\n')
+		if idx == -1 {
+			return l.addLineLength(len(data))
+		}
+
+		if err := l.addLineLength(idx); err != nil {
+			return err
+		}
+		l.newLine()
+		data = data[idx+1:]
+	}
+}
+
+// newLine is called whenever a newline has been read.
+func (l *lexer) newLine() {
+	l.line++
+	l.prevLineLength = l.lineLength
+	l.lineLength = 0
+}
+
+// addLineLength adds n bytes to the length of the current line.
+// systemd truncates lines longer than LINE_MAX
+// (https://bugs.freedesktop.org/show_bug.cgi?id=85308). Rather
+// than allowing this to pass silently, such lines are rejected
+// with ErrLineTooLong.
+func (l *lexer) addLineLength(n int) error {
+	l.lineLength += n
+	if l.maxLineLength > 0 && l.lineLength >= l.maxLineLength {
+		return ErrLineTooLong
+	}
+	return nil
 }
 
 func (l *lexer) lex() {
@@ -148,60 +377,111 @@ func (l *lexer) lex() {
 		close(l.secchan)
 		close(l.errchan)
 	}()
+	done := l.ctx.Done()
 	next := l.lexNextSection
 	for next != nil {
-		if l.buf.Buffered() >= SystemdLineMax {
-			// systemd truncates lines longer than LINE_MAX
-			// https://bugs.freedesktop.org/show_bug.cgi?id=85308
-			// Rather than allowing this to pass silently, let's
-			// explicitly gate people from encountering this
-			line, err := l.buf.Peek(SystemdLineMax)
-			if err != nil {
-				l.errchan <- err
-				return
-			}
-			if !bytes.ContainsAny(line, SystemdNewline) {
-				l.errchan <- ErrLineTooLong
-				return
-			}
+		select {
+		case <-done:
+			l.errchan <- l.ctx.Err()
+			return
+		default:
 		}
 
 		var err error
 		next, err = next()
 		if err != nil {
+			// resource limits enforced while reading the file
+			// are reported with the current line.
+			if _, ok := err.(*ParseError); !ok && errors.Is(err, ErrLimitExceeded) {
+				err = &ParseError{Line: l.line, Err: err}
+			}
 			l.errchan <- err
 			return
 		}
 	}
 
 	if l.section != nil {
-		l.secchan <- l.section
+		if err := l.emit(l.section); err != nil {
+			l.errchan <- err
+		}
 	}
 }
 
 type lexStep func() (lexStep, error)
 
 func (l *lexer) lexSectionName() (lexStep, error) {
-	sec, err := l.buf.ReadBytes(']')
+	line := l.line
+	sec, err := l.readBytes(']')
 	if err != nil {
-		return nil, errors.New("unable to find end of section")
+		if err != io.EOF {
+			return nil, err
+		}
+		return nil, &ParseError{Line: line, Err: errors.New("unable to find end of section")}
 	}
 
 	sectionName := string(sec[:len(sec)-1])
+	var sectionArg string
+	if l.cfg.SectionArgs {
+		sectionName, sectionArg, err = parseSectionHeader(sectionName)
+		if err != nil {
+			return nil, &ParseError{Line: line, Err: err}
+		}
+	}
+
+	l.sections++
+	if l.cfg.MaxSections > 0 && l.sections > l.cfg.MaxSections {
+		return nil, &ParseError{Line: line, Err: fmt.Errorf("%w: more than %d sections", ErrLimitExceeded, l.cfg.MaxSections)}
+	}
 
 	if l.section != nil {
-		l.secchan <- l.section
+		if err := l.emit(l.section); err != nil {
+			return nil, err
+		}
 	}
 
 	l.section = &Section{
 		Name: sectionName,
+		Arg:  sectionArg,
+		Line: line,
 	}
 
 	return l.lexSectionSuffixFunc(), nil
 }
 
+// parseSectionHeader splits the content of a section header into
+// the section name and its argument. The argument is separated from
+// the name by whitespace and may be quoted (see Unquote).
+func parseSectionHeader(header string) (string, string, error) {
+	header = strings.TrimSpace(header)
+
+	idx := strings.IndexFunc(header, unicode.IsSpace)
+	if idx == -1 {
+		return header, "", nil
+	}
+
+	arg, err := Unquote(strings.TrimSpace(header[idx:]))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid section argument: %w", err)
+	}
+
+	return header[:idx], arg, nil
+}
+
+// Header returns the content of the section header without brackets.
+// If the section has an argument it is appended to the section name
+// and quoted if required. Since a section header ends at the first
+// closing bracket, brackets in the argument are escaped.
+func (sec Section) Header() string {
+	if sec.Arg == "" {
+		return sec.Name
+	}
+
+	return sec.Name + " " + strings.ReplaceAll(Quote(sec.Arg), "]", `\x5d`)
+}
+
 func (l *lexer) lexSectionSuffixFunc() lexStep {
 	return func() (lexStep, error) {
+		line := l.line
 		garbage, _, err := l.toEOL()
 		if err != nil {
 			return nil, err
@@ -209,7 +489,7 @@ func (l *lexer) lexSectionSuffixFunc() lexStep {
 
 		garbage = bytes.TrimSpace(garbage)
 		if len(garbage) > 0 {
-			return nil, fmt.Errorf("found garbage after section name %s: %v", l.section, garbage)
+			return nil, &ParseError{Line: line, Err: fmt.Errorf("found garbage after section name %s: %q", l.section.Name, garbage)}
 		}
 
 		return l.lexNextSectionOrOptionFunc(), nil
@@ -238,7 +518,12 @@ func (l *lexer) ignoreLineFunc(next lexStep) lexStep {
 }
 
 func (l *lexer) lexNextSection() (lexStep, error) {
-	r, _, err := l.buf.ReadRune()
+	// everything before the first section header is skipped
+	// so include directives are only detected at the start
+	// of a line.
+	startOfLine := l.lineLength == 0
+
+	r, err := l.readRune()
 	if err != nil {
 		if err == io.EOF {
 			err = nil
@@ -248,8 +533,10 @@ func (l *lexer) lexNextSection() (lexStep, error) {
 
 	if r == '[' {
 		return l.lexSectionName, nil
-	} else if isComment(r) {
+	} else if l.cfg.isComment(r) {
 		return l.ignoreLineFunc(l.lexNextSection), nil
+	} else if r == '.' && startOfLine && l.isInclude() {
+		return l.lexIncludeFunc(l.lexNextSection), nil
 	}
 
 	return l.lexNextSection, nil
@@ -257,7 +544,7 @@ func (l *lexer) lexNextSection() (lexStep, error) {
 
 func (l *lexer) lexNextSectionOrOptionFunc() lexStep {
 	return func() (lexStep, error) {
-		r, _, err := l.buf.ReadRune()
+		r, err := l.readRune()
 		if err != nil {
 			if err == io.EOF {
 				err = nil
@@ -269,26 +556,29 @@ func (l *lexer) lexNextSectionOrOptionFunc() lexStep {
 			return l.lexNextSectionOrOptionFunc(), nil
 		} else if r == '[' {
 			return l.lexSectionName, nil
-		} else if isComment(r) {
+		} else if l.cfg.isComment(r) {
 			return l.ignoreLineFunc(l.lexNextSectionOrOptionFunc()), nil
+		} else if r == '.' && l.isInclude() {
+			return l.lexIncludeFunc(l.lexNextSectionOrOptionFunc()), nil
 		}
 
-		_ = l.buf.UnreadRune()
+		_ = l.unreadRune()
 		return l.lexOptionNameFunc(), nil
 	}
 }
 
 func (l *lexer) lexOptionNameFunc() lexStep {
 	return func() (lexStep, error) {
+		line := l.line
 		var partial bytes.Buffer
 		for {
-			r, _, err := l.buf.ReadRune()
+			r, err := l.readRune()
 			if err != nil {
 				return nil, err
 			}
 
 			if r == '\n' || r == '\r' {
-				return nil, errors.New("unexpected newline encountered while parsing option name")
+				return nil, &ParseError{Line: line, Err: errors.New("unexpected newline encountered while parsing option name")}
 			}
 
 			if r == '=' {
@@ -299,27 +589,27 @@ func (l *lexer) lexOptionNameFunc() lexStep {
 		}
 
 		name := strings.TrimSpace(partial.String())
-		return l.lexOptionValueFunc(name, bytes.Buffer{}), nil
+		return l.lexOptionValueFunc(name, line, 0, bytes.Buffer{}), nil
 	}
 }
 
-func (l *lexer) lexOptionValueFunc(name string, partial bytes.Buffer) lexStep {
+func (l *lexer) lexOptionValueFunc(name string, line, continuations int, partial bytes.Buffer) lexStep {
 	return func() (lexStep, error) {
 		for {
-			line, eof, err := l.toEOL()
+			data, eof, err := l.toEOL()
 			if err != nil {
 				return nil, err
 			}
 
-			if len(bytes.TrimSpace(line)) == 0 {
+			if len(bytes.TrimSpace(data)) == 0 {
 				break
 			}
 
-			partial.Write(line)
+			partial.Write(data)
 
 			// lack of continuation means this value has been exhausted
-			idx := bytes.LastIndex(line, []byte{'\\'})
-			if idx == -1 || idx != (len(line)-1) {
+			idx := bytes.LastIndex(data, []byte{'\\'})
+			if idx == -1 || idx != (len(data)-1) {
 				break
 			}
 
@@ -327,7 +617,12 @@ func (l *lexer) lexOptionValueFunc(name string, partial bytes.Buffer) lexStep {
 				partial.WriteRune('\n')
 			}
 
-			return l.lexOptionValueFunc(name, partial), nil // nolint:staticcheck
+			continuations++
+			if l.cfg.MaxContinuationLines > 0 && continuations > l.cfg.MaxContinuationLines {
+				return nil, &ParseError{Line: line, Err: fmt.Errorf("%w: %s has more than %d continuation lines", ErrLimitExceeded, name, l.cfg.MaxContinuationLines)}
+			}
+
+			return l.lexOptionValueFunc(name, line, continuations, partial), nil // nolint:staticcheck
 		}
 
 		val := partial.String()
@@ -340,19 +635,86 @@ func (l *lexer) lexOptionValueFunc(name string, partial bytes.Buffer) lexStep {
 		}
 
 		if l.section == nil {
-			return nil, fmt.Errorf("found option outside of section")
+			return nil, &ParseError{Line: line, Err: fmt.Errorf("found option outside of section")}
+		}
+
+		if l.cfg.MaxOptionsPerSection > 0 && len(l.section.Options) >= l.cfg.MaxOptionsPerSection {
+			return nil, &ParseError{Line: line, Err: fmt.Errorf("%w: section %s has more than %d options", ErrLimitExceeded, l.section.Name, l.cfg.MaxOptionsPerSection)}
 		}
 
 		l.section.Options = append(l.section.Options, Option{Name: name, Value: val})
+		l.section.addOptionLine(name, line)
 
 		return l.lexNextSectionOrOptionFunc(), nil
 	}
 }
 
+// includeDirective is the include directive without
+// the leading dot.
+const includeDirective = "include"
+
+// isInclude returns true if the next bytes in the buffer form an
+// include directive and include directives are supported. It is
+// called after a leading dot has been read.
+func (l *lexer) isInclude() bool {
+	if l.include == nil {
+		return false
+	}
+
+	data, _ := l.buf.Peek(len(includeDirective) + 1)
+	if len(data) <= len(includeDirective) {
+		return false
+	}
+
+	return string(data[:len(includeDirective)]) == includeDirective &&
+		(data[len(includeDirective)] == ' ' || data[len(includeDirective)] == '\t')
+}
+
+// lexIncludeFunc handles an include directive and continues with next.
+// Sections of the included file are emitted right after the current
+// section so options following the directive are still added to the
+// current section. If there is no current section yet, the included
+// sections are emitted immediately.
+func (l *lexer) lexIncludeFunc(next lexStep) lexStep {
+	return func() (lexStep, error) {
+		line := l.line
+		data, _, err := l.toEOL()
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSpace(string(data[len(includeDirective):]))
+		if name == "" {
+			return nil, &ParseError{Line: line, Err: errors.New("missing path for include directive")}
+		}
+
+		sections, err := l.include(name)
+		if err != nil {
+			if _, ok := err.(*ParseError); ok || err == l.ctx.Err() {
+				return nil, err
+			}
+			return nil, &ParseError{Line: line, Err: fmt.Errorf("include %s: %w", name, err)}
+		}
+
+		l.included = append(l.included, sections...)
+		if l.section == nil {
+			for _, sec := range l.includedSections() {
+				select {
+				case l.secchan <- sec:
+				case <-l.ctx.Done():
+					return nil, l.ctx.Err()
+				}
+			}
+		}
+
+		return next, nil
+	}
+}
+
 // toEOL reads until the end-of-line or end-of-file.
 // Returns (data, EOFfound, error)
 func (l *lexer) toEOL() ([]byte, bool, error) {
-	line, err := l.buf.ReadBytes('\n')
+	line, err := l.readBytes('\n')
 	// ignore EOF here since it's roughly equivalent to EOL
 	if err != nil && err != io.EOF {
 		return nil, false, err
@@ -363,7 +725,3 @@ func (l *lexer) toEOL() ([]byte, bool, error) {
 
 	return line, err == io.EOF, nil
 }
-
-func isComment(r rune) bool {
-	return r == '#' || r == ';'
-}
@@ -0,0 +1,198 @@
+package conf
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/shlex"
+)
+
+// ListSplit defines how the value of a slice option is split
+// into multiple values.
+type ListSplit string
+
+// All supported list split modes.
+const (
+	// SplitNone does not split values. Multiple values can only be
+	// specified by repeating the option. This is the default.
+	SplitNone ListSplit = ""
+
+	// SplitWhitespace splits values at whitespace like systemd
+	// does for After= or Wants=.
+	SplitWhitespace ListSplit = "whitespace"
+
+	// SplitComma splits values at commas. Whitespace around each
+	// value is removed.
+	SplitComma ListSplit = "comma"
+
+	// SplitShell splits values using shell-style quoting rules so
+	// values may contain whitespace if quoted (like Environment=).
+	SplitShell ListSplit = "shell"
+)
+
+// SplitValue splits value into multiple values according to mode.
+// Empty values are dropped.
+func SplitValue(value string, mode ListSplit) ([]string, error) {
+	switch mode {
+	case SplitNone:
+		return []string{value}, nil
+
+	case SplitWhitespace:
+		return strings.Fields(value), nil
+
+	case SplitComma:
+		var result []string
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				result = append(result, part)
+			}
+		}
+		return result, nil
+
+	case SplitShell:
+		return shlex.Split(value)
+	}
+
+	return nil, fmt.Errorf("unknown list split mode %q", string(mode))
+}
+
+// SplitValues splits all values assigned to the option described by
+// spec according to spec.Split and returns the resulting list. Like in
+// systemd, an empty assignment resets the list. If spec does not describe
+// a slice option or does not declare a split mode, values are returned
+// as they are.
+//
+// Values are always stored unsplit in a File so drop-ins and other
+// configuration layers are merged on the original assignments. Splitting
+// is applied when values are validated or decoded.
+//
+// If spec is also annotated with QuotedValue(), separators inside quotes
+// are ignored and each resulting value is unquoted (see Unquote), like
+// systemd's extract_first_word does. For example, `"a b" c` is split into
+// "a b" and "c".
+func (spec OptionSpec) SplitValues(values []string) ([]string, error) {
+	if !spec.splitsValues() {
+		return values, nil
+	}
+	quoted := IsQuoted(spec)
+
+	var result []string
+	for _, val := range values {
+		if val == "" {
+			result = nil
+			continue
+		}
+
+		var (
+			parts []string
+			err   error
+		)
+		if quoted {
+			parts, err = splitQuotedValue(val, spec.Split)
+		} else {
+			parts, err = SplitValue(val, spec.Split)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, parts...)
+	}
+
+	return result, nil
+}
+
+// splitsValues returns true if the values of the option described
+// by spec are split according to spec.Split.
+func (spec OptionSpec) splitsValues() bool {
+	return spec.Split != SplitNone && spec.Type.IsSliceType()
+}
+
+// splitQuotedValue splits value at separators that are neither quoted
+// nor escaped and unquotes each part. SplitComma splits at commas while
+// all other modes split at whitespace. Unquoted empty parts are dropped.
+func splitQuotedValue(value string, mode ListSplit) ([]string, error) {
+	isSep := unicode.IsSpace
+	switch mode {
+	case SplitComma:
+		isSep = func(r rune) bool { return r == ',' }
+	case SplitWhitespace, SplitShell:
+	default:
+		return nil, fmt.Errorf("unknown list split mode %q", string(mode))
+	}
+
+	var (
+		result []string
+		quote  rune
+		start  int
+	)
+	add := func(raw string) error {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			return nil
+		}
+
+		word, err := Unquote(raw)
+		if err != nil {
+			return err
+		}
+		result = append(result, word)
+		return nil
+	}
+
+	escaped := false
+	for idx, r := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case isSep(r):
+			if err := add(value[start:idx]); err != nil {
+				return nil, err
+			}
+			start = idx + utf8.RuneLen(r)
+		}
+	}
+
+	if err := add(value[start:]); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetSpecValues returns all values of the option described by spec
+// split according to spec.Split. See OptionSpec.SplitValues.
+func (opts Options) GetSpecValues(spec OptionSpec) ([]string, error) {
+	return spec.SplitValues(opts.GetStringSlice(spec.Name))
+}
+
+// GetSpecAs is like GetAs but uses the name and type of spec and splits
+// values according to spec.Split. If the option is not set or the values
+// split into an empty list nil is returned. Like GetAs, GetSpecAs panics
+// if the values cannot be split or decoded.
+func (opts Options) GetSpecAs(spec OptionSpec) interface{} {
+	values, err := opts.GetSpecValues(spec)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	var x interface{}
+	if err := DecodeValues(values, spec.Type, &x); err != nil {
+		panic(err)
+	}
+	return x
+}
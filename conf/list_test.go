@@ -0,0 +1,180 @@
+package conf
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitValue(t *testing.T) {
+	cases := []struct {
+		Value    string
+		Mode     ListSplit
+		Expected []string
+	}{
+		{"a  b", SplitNone, []string{"a  b"}},
+		{" a.service\tb.service  c.service ", SplitWhitespace, []string{"a.service", "b.service", "c.service"}},
+		{"a, b,,c ", SplitComma, []string{"a", "b", "c"}},
+		{`A=1 "B=2 3" C='4'`, SplitShell, []string{"A=1", "B=2 3", "C=4"}},
+	}
+
+	for idx, c := range cases {
+		res, err := SplitValue(c.Value, c.Mode)
+		assert.NoError(t, err, "case #%d", idx)
+		assert.Equal(t, c.Expected, res, "case #%d", idx)
+	}
+
+	_, err := SplitValue(`"unterminated`, SplitShell)
+	assert.Error(t, err)
+
+	_, err = SplitValue("a", ListSplit("unknown"))
+	assert.Error(t, err)
+}
+
+func TestSplitValues(t *testing.T) {
+	spec := OptionSpec{
+		Name:  "After",
+		Type:  StringSliceType,
+		Split: SplitWhitespace,
+	}
+
+	res, err := spec.SplitValues([]string{"a b", "c", "", "d e"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d", "e"}, res)
+
+	// non-slice types are never split.
+	spec.Type = StringType
+	res, err = spec.SplitValues([]string{"a b"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a b"}, res)
+}
+
+func TestListSplitOptions(t *testing.T) {
+	spec := FileSpec{
+		"unit": SectionSpec{
+			{Name: "After", Type: StringSliceType, Split: SplitWhitespace},
+			{Name: "Ports", Type: IntSliceType, Split: SplitComma},
+			{Name: "Environment", Type: StringSliceType, Split: SplitShell},
+		},
+	}
+
+	content := "[Unit]\nAfter=a.service b.service\nAfter=c.service\nPorts=80, 443\nEnvironment=A=1 \"B=2 3\"\n"
+	f, err := Deserialize("", strings.NewReader(content))
+	require.NoError(t, err)
+	require.NoError(t, ValidateFile(f, spec))
+
+	// GetAs and SectionDecoder
+	sec := f.Sections[0]
+	assert.Equal(t, []string{"a.service", "b.service", "c.service"}, sec.GetSpecAs(spec["unit"].(SectionSpec)[0]))
+	dec := NewSectionDecoder(spec["unit"].(SectionSpec))
+	assert.Equal(t, []int{80, 443}, dec.Get(sec, "Ports"))
+
+	// decoding
+	var target struct {
+		Unit struct {
+			After       []string
+			Ports       []int
+			Environment []string
+		}
+	}
+	require.NoError(t, DecodeFile(f, &target, spec))
+	assert.Equal(t, []string{"a.service", "b.service", "c.service"}, target.Unit.After)
+	assert.Equal(t, []int{80, 443}, target.Unit.Ports)
+	assert.Equal(t, []string{"A=1", "B=2 3"}, target.Unit.Environment)
+
+	// drop-ins append and reset assignments.
+	dropin, err := Deserialize("", strings.NewReader("[Unit]\nAfter=\nAfter=d.service e.service\nPorts=8080\n"))
+	require.NoError(t, err)
+	require.NoError(t, ApplyDropIns(f, []*DropIn{(*DropIn)(dropin)}, spec))
+
+	values, err := f.Sections[0].GetSpecValues(spec["unit"].(SectionSpec)[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d.service", "e.service"}, values)
+
+	values, err = f.Sections[0].GetSpecValues(spec["unit"].(SectionSpec)[1])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"80", "443", "8080"}, values)
+
+	// validation checks each value.
+	f, err = Deserialize("", strings.NewReader("[Unit]\nPorts=80, http\n"))
+	require.NoError(t, err)
+	err = ValidateFile(f, spec)
+	assert.True(t, errors.Is(err, ErrInvalidNumber))
+
+	f, err = Deserialize("", strings.NewReader("[Unit]\nEnvironment=\"A=1\n"))
+	require.NoError(t, err)
+	var ve *ValidationError
+	if assert.True(t, errors.As(ValidateFile(f, spec), &ve)) {
+		assert.Equal(t, "Environment", ve.Option)
+		assert.Equal(t, 2, ve.Line)
+	}
+}
+
+func TestSplitQuotedValues(t *testing.T) {
+	quoted := new(Annotation).With(QuotedValue())
+
+	cases := []struct {
+		Values   []string
+		Mode     ListSplit
+		Expected []string
+	}{
+		{[]string{`"a b" c`}, SplitWhitespace, []string{"a b", "c"}},
+		{[]string{`'x\ty' "" z\sw`}, SplitWhitespace, []string{"x\ty", "", "z w"}},
+		{[]string{`a, "b, c" ,'d,e'`}, SplitComma, []string{"a", "b, c", "d,e"}},
+		{[]string{`A=1 "B=2 3"`, "", `C='4 5'`}, SplitShell, []string{"C=4 5"}},
+	}
+
+	for idx, c := range cases {
+		spec := OptionSpec{Name: "L", Type: StringSliceType, Split: c.Mode, Annotations: quoted}
+
+		res, err := spec.SplitValues(c.Values)
+		assert.NoError(t, err, "case #%d", idx)
+		assert.Equal(t, c.Expected, res, "case #%d", idx)
+	}
+
+	spec := OptionSpec{Name: "L", Type: StringSliceType, Split: SplitWhitespace, Annotations: quoted}
+	_, err := spec.SplitValues([]string{`"a b`})
+	assert.True(t, errors.Is(err, ErrUnterminatedQuote))
+}
+
+func TestSplitQuotedValuesFile(t *testing.T) {
+	spec := FileSpec{
+		"test": SectionSpec{
+			{
+				Name:        "L",
+				Type:        StringSliceType,
+				Split:       SplitWhitespace,
+				Annotations: new(Annotation).With(QuotedValue()),
+			},
+		},
+	}
+
+	f, err := Deserialize("", strings.NewReader("[Test]\nL=\"a b\" c\n"))
+	require.NoError(t, err)
+
+	// UnquoteValues must keep the quotes of split options so the
+	// result is the same with and without it.
+	unquoted, err := UnquoteValues(f, spec)
+	require.NoError(t, err)
+
+	for _, file := range []*File{f, unquoted} {
+		values, err := file.Sections[0].GetSpecValues(spec["test"].(SectionSpec)[0])
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a b", "c"}, values)
+
+		var target struct {
+			Test struct {
+				L []string
+			}
+		}
+		require.NoError(t, DecodeFile(file, &target, spec))
+		assert.Equal(t, []string{"a b", "c"}, target.Test.L)
+	}
+
+	buf := new(strings.Builder)
+	require.NoError(t, WriteSectionsTo(unquoted.Sections, buf, WriteConfig{Spec: spec}))
+	assert.Equal(t, "[Test]\nL= \"a b\" c\n", buf.String())
+}
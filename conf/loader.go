@@ -0,0 +1,336 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Suggested priorities for commonly used configuration layers.
+// Layers with a higher priority override layers with a lower one.
+const (
+	PriorityDefaults = 0
+	PriorityVendor   = 100
+	PriorityAdmin    = 200
+	PriorityDropIns  = 300
+	PriorityEnv      = 400
+	PriorityFlags    = 500
+)
+
+// Layer is a single configuration layer that can be added to
+// a Loader.
+type Layer interface {
+	// Name returns a human readable name of the layer. It's
+	// used as the origin of option values.
+	Name() string
+
+	// Load loads all files of the layer. Files are applied in
+	// order. A layer that does not contribute any configuration
+	// should return an empty slice.
+	Load(reg SectionRegistry) ([]*File, error)
+}
+
+// LayerFunc is a convenience type for implementing a Layer.
+type LayerFunc struct {
+	LayerName string
+	LoadFunc  func(reg SectionRegistry) ([]*File, error)
+}
+
+// Name implements Layer.
+func (lf LayerFunc) Name() string { return lf.LayerName }
+
+// Load implements Layer.
+func (lf LayerFunc) Load(reg SectionRegistry) ([]*File, error) { return lf.LoadFunc(reg) }
+
+// StaticLayer returns a layer that always contributes f.
+func StaticLayer(name string, f *File) Layer {
+	return LayerFunc{
+		LayerName: name,
+		LoadFunc: func(SectionRegistry) ([]*File, error) {
+			return []*File{f.Clone()}, nil
+		},
+	}
+}
+
+// FileLayer returns a layer that loads the file at path. If optional
+// is true a missing file is ignored.
+func FileLayer(path string, optional bool) Layer {
+	return LayerFunc{
+		LayerName: path,
+		LoadFunc: func(SectionRegistry) ([]*File, error) {
+			f, err := LoadFile(path)
+			if err != nil {
+				if optional && os.IsNotExist(err) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return []*File{f}, nil
+		},
+	}
+}
+
+// DropInLayer returns a layer that loads all drop-in files for unitName.
+// See LoadDropIns for more information.
+func DropInLayer(unitName string, searchPath []string) Layer {
+	return LayerFunc{
+		LayerName: "drop-ins",
+		LoadFunc: func(SectionRegistry) ([]*File, error) {
+			dropins, err := LoadDropIns(unitName, searchPath)
+			if err != nil {
+				return nil, err
+			}
+
+			files := make([]*File, len(dropins))
+			for idx, d := range dropins {
+				files[idx] = (*File)(d)
+			}
+			return files, nil
+		},
+	}
+}
+
+// EnvLayer returns a layer that parses configuration from environment
+// variables. See ParseFromEnv for more information. Empty values for
+// slice options reset the slice (see EnvConfig.ResetEmptySlices).
+func EnvLayer(prefix string, env []string, opts ...EnvConfig) Layer {
+	var cfg EnvConfig
+	if len(opts) > 0 {
+		cfg = opts[0]
+	}
+	cfg.ResetEmptySlices = true
+
+	return LayerFunc{
+		LayerName: "env",
+		LoadFunc: func(reg SectionRegistry) ([]*File, error) {
+			f, err := ParseFromEnv(prefix, env, reg, cfg)
+			if err != nil {
+				return nil, err
+			}
+			return []*File{f}, nil
+		},
+	}
+}
+
+// FlagsLayer returns a layer that contributes all options set via
+// command line flags. The flag set must be parsed before the loader
+// is used. See BindFlags for more information.
+func FlagsLayer(fl *FlagLayer) Layer {
+	return LayerFunc{
+		LayerName: "flags",
+		LoadFunc: func(SectionRegistry) ([]*File, error) {
+			return []*File{fl.File()}, nil
+		},
+	}
+}
+
+// Origin describes where the value of an option came from.
+type Origin struct {
+	// Layer is the name of the layer.
+	Layer string
+
+	// Path is the path of the file within the layer, if any.
+	Path string
+}
+
+// OriginDefault is used as the layer name for option values
+// that have been set from the default of the option spec.
+const OriginDefault = "default"
+
+// OptionKey identifies an option in a file that may contain
+// sections multiple times.
+type OptionKey struct {
+	// Section is the lower-case name of the section.
+	Section string
+
+	// Arg is the argument of the section (see Section.Arg).
+	Arg string
+
+	// Index is the index of the section among all sections
+	// with the same name and argument.
+	Index int
+
+	// Option is the lower-case name of the option.
+	Option string
+}
+
+// Provenance maps options to the origins of their values. The last
+// origin is the one that set the final value. Slice options may have
+// multiple origins if values have been appended by multiple layers.
+type Provenance map[OptionKey][]Origin
+
+// Lookup returns the origins of the option name in the first section
+// named section that does not have an argument.
+func (p Provenance) Lookup(section, name string) []Origin {
+	return p.LookupArg(section, "", name)
+}
+
+// LookupArg is like Lookup but returns the origins of the option name
+// in the first section named section with the argument arg.
+func (p Provenance) LookupArg(section, arg, name string) []Origin {
+	return p[OptionKey{
+		Section: strings.ToLower(section),
+		Arg:     arg,
+		Option:  strings.ToLower(name),
+	}]
+}
+
+// LoadResult is returned by Loader.Load.
+type LoadResult struct {
+	// File is the merged and validated configuration.
+	File *File
+
+	// Provenance holds the origins of all option values.
+	Provenance Provenance
+}
+
+// Loader loads configuration from multiple layers with explicit
+// precedence. Layers are applied in order of priority, starting
+// with the lowest one, using the same semantics as OverlayFile. Layers
+// with the same priority are applied in the order they have been added.
+type Loader struct {
+	spec       SectionRegistry
+	validation []ValidationConfig
+	layers     []prioritizedLayer
+}
+
+type prioritizedLayer struct {
+	priority int
+	layer    Layer
+}
+
+// NewLoader returns a new loader that validates the merged
+// configuration against spec.
+func NewLoader(spec SectionRegistry, opts ...ValidationConfig) *Loader {
+	return &Loader{
+		spec:       spec,
+		validation: opts,
+	}
+}
+
+// Add adds layer with the given priority.
+func (l *Loader) Add(priority int, layer Layer) *Loader {
+	l.layers = append(l.layers, prioritizedLayer{
+		priority: priority,
+		layer:    layer,
+	})
+	return l
+}
+
+// Load loads and merges all layers, validates the result and, if
+// target is not nil, decodes it into target (see DecodeFile).
+func (l *Loader) Load(target interface{}) (*LoadResult, error) {
+	layers := make([]prioritizedLayer, len(l.layers))
+	copy(layers, l.layers)
+	sort.SliceStable(layers, func(i, j int) bool {
+		return layers[i].priority < layers[j].priority
+	})
+
+	result := &LoadResult{
+		File:       new(File),
+		Provenance: make(Provenance),
+	}
+
+	for _, pl := range layers {
+		files, err := pl.layer.Load(l.spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pl.layer.Name(), err)
+		}
+
+		for _, f := range files {
+			if f == nil {
+				continue
+			}
+
+			if err := OverlayFile(result.File, f, l.spec); err != nil {
+				return nil, fmt.Errorf("%s: %w", pl.layer.Name(), err)
+			}
+
+			result.Provenance.record(f, Origin{
+				Layer: pl.layer.Name(),
+				Path:  f.Path,
+			}, l.spec)
+		}
+	}
+
+	if err := ValidateFile(result.File, l.spec, l.validation...); err != nil {
+		return nil, err
+	}
+
+	// drop the provenance of options that have been reset without
+	// new values. All options without provenance have been added by
+	// ValidateFile from their default value.
+	provenance := make(Provenance)
+	walkOptions(result.File, func(key OptionKey, _ Section) {
+		origins, ok := result.Provenance[key]
+		if !ok {
+			origins = []Origin{{Layer: OriginDefault}}
+		}
+		provenance[key] = origins
+	})
+	result.Provenance = provenance
+
+	if target != nil {
+		if err := DecodeFile(result.File, target, l.spec); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// record records origin for all options set in f. Options of
+// included sections are recorded with the path of the included file.
+func (p Provenance) record(f *File, origin Origin, reg SectionRegistry) {
+	seen := make(map[OptionKey]bool)
+
+	walkOptions(f, func(key OptionKey, sec Section) {
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+
+		var (
+			spec OptionSpec
+			ok   bool
+		)
+		if optReg, found := reg.OptionsForSection(key.Section); found && optReg != nil {
+			spec, ok = optReg.GetOption(key.Option)
+		}
+
+		values := sec.GetStringSlice(key.Option)
+		if !ok || !spec.Type.IsSliceType() || values[0] == "" {
+			p[key] = nil
+		}
+
+		o := origin
+		if sec.Source != "" {
+			// the section has been included from another file.
+			o.Path = sec.Source
+		}
+
+		p[key] = append(p[key], o)
+	})
+}
+
+// walkOptions calls fn for each option in f together with the
+// section that contains it. Sections are indexed by their name and
+// argument the same way OverlayFile merges them.
+func walkOptions(f *File, fn func(key OptionKey, sec Section)) {
+	sectionCount := make(map[sectionKey]int)
+	for _, sec := range f.Sections {
+		sk := keyOfSection(sec)
+		idx := sectionCount[sk]
+		sectionCount[sk]++
+
+		for _, opt := range sec.Options {
+			fn(OptionKey{
+				Section: sk.name,
+				Arg:     sk.arg,
+				Index:   idx,
+				Option:  strings.ToLower(opt.Name),
+			}, sec)
+		}
+	}
+}
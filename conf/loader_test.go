@@ -0,0 +1,74 @@
+package conf_test
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+
+	"github.com/ppacher/system-conf/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoader(t *testing.T) {
+	root, write := conf.NewTestDir(t)
+
+	vendor := write("lib/app.conf", "[Global]\nName=vendor\nTags=vendor\nPort=80\n")
+	admin := write("etc/app.conf", "[Global]\nName=admin\n")
+	dropin := write("etc/app.conf.d/10-tags.conf", "[Global]\nTags=\nTags=dropin\n")
+
+	spec := conf.FileSpec{
+		"Global": conf.SectionSpec{
+			{Name: "Name", Type: conf.StringType},
+			{Name: "Tags", Type: conf.StringSliceType},
+			{Name: "Port", Type: conf.IntType},
+			{Name: "LogLevel", Type: conf.StringType, Default: "info"},
+			{Name: "Debug", Type: conf.BoolType},
+		},
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := conf.BindFlags(fs, spec)
+	assert.NoError(t, fs.Parse([]string{"--global.port=8080"}))
+
+	type Global struct {
+		Name     string
+		Tags     []string
+		Port     int
+		LogLevel string
+		Debug    bool
+	}
+	var target struct {
+		Global Global
+	}
+
+	// layers are intentionally added out of order.
+	res, err := conf.NewLoader(spec).
+		Add(conf.PriorityFlags, conf.FlagsLayer(flags)).
+		Add(conf.PriorityEnv, conf.EnvLayer("APP", []string{"APP_GLOBAL_TAGS=env", "APP_GLOBAL_PORT=443"})).
+		Add(conf.PriorityVendor, conf.FileLayer(vendor, false)).
+		Add(conf.PriorityAdmin, conf.FileLayer(admin, false)).
+		Add(conf.PriorityAdmin, conf.FileLayer(filepath.Join(root, "etc/missing.conf"), true)).
+		Add(conf.PriorityDropIns, conf.DropInLayer("app.conf", []string{filepath.Join(root, "lib"), filepath.Join(root, "etc")})).
+		Load(&target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, Global{
+		Name:     "admin",
+		Tags:     []string{"dropin", "env"},
+		Port:     8080,
+		LogLevel: "info",
+	}, target.Global)
+
+	assert.Equal(t, []conf.Origin{{Layer: admin, Path: admin}}, res.Provenance.Lookup("Global", "Name"))
+	assert.Equal(t, []conf.Origin{{Layer: "drop-ins", Path: dropin}, {Layer: "env"}}, res.Provenance.Lookup("global", "tags"))
+	assert.Equal(t, []conf.Origin{{Layer: "flags"}}, res.Provenance.Lookup("global", "port"))
+	assert.Equal(t, []conf.Origin{{Layer: conf.OriginDefault}}, res.Provenance.Lookup("global", "loglevel"))
+	assert.Nil(t, res.Provenance.Lookup("global", "debug"))
+
+	_, err = conf.NewLoader(spec).
+		Add(conf.PriorityVendor, conf.FileLayer(filepath.Join(root, "etc/missing.conf"), false)).
+		Load(nil)
+	assert.Error(t, err)
+}
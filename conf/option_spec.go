@@ -38,6 +38,11 @@ type OptionSpec struct {
 	// the help page.
 	Internal bool `json:"internal,omitempty"`
 
+	// Split defines how values of slice options are split into
+	// multiple values. By default, each option assignment is a single
+	// value. Split is ignored for non-slice types. See SplitValues.
+	Split ListSplit `json:"split,omitempty"`
+
 	// Annotations can be used to add arbitrary metadata to
 	// option definitions. For example, such annotations can
 	// be later used in help or documentation generators.
@@ -0,0 +1,330 @@
+package conf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// DefaultCommentChars are the characters that start a comment
+// line unless configured otherwise in ParseConfig.
+const DefaultCommentChars = "#;"
+
+// DefaultMaxIncludes is the maximum number of include directives
+// processed for a single file unless configured otherwise in
+// ParseConfig.
+const DefaultMaxIncludes = 256
+
+// ParseConfig can be passed to DeserializeContext and all other
+// context-aware loading functions to configure the parser. The zero
+// value parses files the same way systemd does. Use RelaxedParseConfig
+// for files that are not meant to be read by systemd.
+//
+// ParseConfig also allows to limit the resources used when parsing
+// untrusted input. Limits that are zero or negative are not enforced.
+// Any violation is reported as a *ParseError that wraps ErrLimitExceeded.
+type ParseConfig struct {
+	// MaxLineLength is the maximum length of a single line in bytes,
+	// including the newline. Longer lines are rejected with
+	// ErrLineTooLong. Defaults to SystemdLineMax. The limit is enforced
+	// while reading so it does not affect the memory used for parsing.
+	MaxLineLength int
+
+	// UnlimitedLineLength disables the line length check. This is
+	// useful for files that embed certificates or long JSON values.
+	// MaxLineLength is ignored if set.
+	UnlimitedLineLength bool
+
+	// CommentChars holds all characters that start a comment if they
+	// are the first non-whitespace character of a line. Defaults to
+	// DefaultCommentChars.
+	CommentChars string
+
+	// NoSemicolonComments may be set to true to not treat lines
+	// starting with a semicolon as comments, even if ';' is
+	// part of CommentChars.
+	NoSemicolonComments bool
+
+	// MaxFileSize is the maximum size of a file in bytes.
+	MaxFileSize int64
+
+	// MaxSections is the maximum number of sections in a file.
+	MaxSections int
+
+	// MaxOptionsPerSection is the maximum number of options in
+	// a single section.
+	MaxOptionsPerSection int
+
+	// MaxContinuationLines is the maximum number of continuation
+	// lines of a single option value.
+	MaxContinuationLines int
+
+	// Includes may be set to true to support include directives.
+	// A line of the form
+	//
+	//	.include path/to/file.conf
+	//
+	// is replaced with all sections of the referenced file. Sections
+	// of the included file are added after the section that contains
+	// the directive. Directives before the first section header must
+	// start at the beginning of a line and add the included sections
+	// before the first section of the including file. All other content
+	// before the first section header is ignored as usual so options are
+	// never added to a section of an included file. Paths are resolved against the directory of the
+	// including file first and against each directory in IncludePath
+	// afterwards. Absolute paths and paths that leave these directories
+	// are rejected with ErrIncludeNotAllowed. Include cycles are
+	// rejected with ErrIncludeCycle. The limits configured in
+	// ParseConfig apply to each included file separately, except
+	// for MaxIncludes.
+	Includes bool
+
+	// MaxIncludes is the maximum number of include directives that
+	// are processed for a file, including the directives of all
+	// included files. This prevents files that include the same
+	// file over and over again from growing exponentially.
+	// Defaults to DefaultMaxIncludes.
+	MaxIncludes int
+
+	// IncludePath holds additional directories that are searched for
+	// include paths.
+	IncludePath []string
+
+	// IncludeFS is the file system included files are loaded from.
+	// If nil, functions that load files from a file system load
+	// included files from the same file system while
+	// DeserializeContext rejects include directives with
+	// ErrIncludeNotAllowed.
+	IncludeFS fs.FS
+
+	// SectionArgs may be set to true to parse section headers that
+	// carry an argument, like git-config style sub-sections:
+	//
+	//	[Remote "origin"]
+	//	[Match host=*.example.com]
+	//
+	// The header is split at the first whitespace into the section
+	// name and its argument which is unquoted and stored in
+	// Section.Arg. Registries are consulted using the name only.
+	SectionArgs bool
+}
+
+// maxIncludeDepth limits how deep include directives may be nested.
+const maxIncludeDepth = 32
+
+// RelaxedParseConfig returns a parse configuration that does not
+// limit the length of lines.
+func RelaxedParseConfig() ParseConfig {
+	return ParseConfig{
+		UnlimitedLineLength: true,
+	}
+}
+
+// maxIncludes returns the maximum number of include directives.
+func (cfg ParseConfig) maxIncludes() int {
+	if cfg.MaxIncludes > 0 {
+		return cfg.MaxIncludes
+	}
+	return DefaultMaxIncludes
+}
+
+// maxLineLength returns the maximum line length or zero
+// if the line length is unlimited.
+func (cfg ParseConfig) maxLineLength() int {
+	switch {
+	case cfg.UnlimitedLineLength:
+		return 0
+	case cfg.MaxLineLength > 0:
+		return cfg.MaxLineLength
+	default:
+		return SystemdLineMax
+	}
+}
+
+// isComment returns true if r starts a comment.
+func (cfg ParseConfig) isComment(r rune) bool {
+	if r == ';' && cfg.NoSemicolonComments {
+		return false
+	}
+
+	chars := cfg.CommentChars
+	if chars == "" {
+		chars = DefaultCommentChars
+	}
+
+	return strings.ContainsRune(chars, r)
+}
+
+// DeserializeContext is like Deserialize but stops parsing once ctx
+// is cancelled and enforces the limits configured in opts. If ctx is
+// cancelled, ctx.Err() is returned.
+func DeserializeContext(ctx context.Context, path string, r io.Reader, opts ...ParseConfig) (*File, error) {
+	var cfg ParseConfig
+	if len(opts) > 0 {
+		cfg = opts[0]
+	}
+
+	return deserialize(ctx, cfg.IncludeFS, path, r, cfg, nil, nil)
+}
+
+// deserialize parses r using cfg. Include directives are loaded from
+// cfg.IncludeFS or fsys, if unset. If both are nil, include directives
+// are rejected. stack holds the paths of all files that include path
+// and includes counts the include directives processed so far. It is
+// shared by all files of the include tree.
+func deserialize(ctx context.Context, fsys fs.FS, filePath string, r io.Reader, cfg ParseConfig, stack []string, includes *int) (*File, error) {
+	if cfg.MaxFileSize > 0 {
+		r = &sizeLimitReader{r: r, remaining: cfg.MaxFileSize, max: cfg.MaxFileSize}
+	}
+
+	if cfg.IncludeFS != nil {
+		fsys = cfg.IncludeFS
+	}
+
+	var include includeFunc
+	if cfg.Includes {
+		stack = append(stack[:len(stack):len(stack)], filePath)
+		if includes == nil {
+			includes = new(int)
+		}
+		include = func(name string) (Sections, error) {
+			return loadInclude(ctx, fsys, name, cfg, stack, includes)
+		}
+	}
+
+	lexer, secchan, errchan := newLexer(ctx, r, cfg, include)
+	go lexer.lex()
+
+	var sections Sections
+	for sec := range secchan {
+		sections = append(sections, *sec)
+	}
+
+	err := <-errchan
+	return &File{Path: filePath, Sections: sections}, err
+}
+
+// loadInclude loads the sections of the file name included by the
+// last file in stack. Errors of the included file are reported as
+// *ParseError with Source set to the path of the included file.
+func loadInclude(ctx context.Context, fsys fs.FS, name string, cfg ParseConfig, stack []string, includes *int) (Sections, error) {
+	if fsys == nil {
+		return nil, fmt.Errorf("%w: no file system to load included files from", ErrIncludeNotAllowed)
+	}
+
+	if len(stack) > maxIncludeDepth {
+		return nil, fmt.Errorf("%w: includes nested deeper than %d levels", ErrLimitExceeded, maxIncludeDepth)
+	}
+
+	*includes++
+	if max := cfg.maxIncludes(); *includes > max {
+		return nil, fmt.Errorf("%w: more than %d includes", ErrLimitExceeded, max)
+	}
+
+	includePath, err := resolveInclude(fsys, name, stack[len(stack)-1], cfg.IncludePath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range stack {
+		if path.Clean(p) == includePath {
+			return nil, fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(append(stack, includePath), " -> "))
+		}
+	}
+
+	f, err := fsys.Open(includePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file, err := deserialize(ctx, fsys, includePath, f, cfg, stack, includes)
+	if err != nil {
+		var pe *ParseError
+		if errors.As(err, &pe) && pe.Source == "" {
+			return nil, &ParseError{Source: includePath, Line: pe.Line, Err: pe.Err}
+		}
+		return nil, err
+	}
+
+	for idx := range file.Sections {
+		if file.Sections[idx].Source == "" {
+			file.Sections[idx].Source = includePath
+		}
+	}
+
+	return file.Sections, nil
+}
+
+// resolveInclude returns the path of the file name included by the
+// file at from. Names are resolved against the directory of from
+// first and against each directory in searchPath afterwards. Names
+// must not be absolute or refer to a parent directory.
+func resolveInclude(fsys fs.FS, name, from string, searchPath []string) (string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%w: %s is outside of the include directories", ErrIncludeNotAllowed, name)
+	}
+
+	candidates := []string{path.Join(path.Dir(from), clean)}
+	for _, dir := range searchPath {
+		candidates = append(candidates, path.Join(dir, clean))
+	}
+
+	for _, c := range candidates {
+		if _, err := fs.Stat(fsys, c); err == nil {
+			return c, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+}
+
+// LoadFileContext is like LoadFileFS but stops parsing once ctx is
+// cancelled and enforces the limits configured in opts.
+func LoadFileContext(ctx context.Context, fsys fs.FS, path string, opts ...ParseConfig) (*File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cfg ParseConfig
+	if len(opts) > 0 {
+		cfg = opts[0]
+	}
+
+	return deserialize(ctx, fsys, path, f, cfg, nil, nil)
+}
+
+// sizeLimitReader returns an error once more than max bytes
+// have been read from r.
+type sizeLimitReader struct {
+	r         io.Reader
+	remaining int64
+	max       int64
+}
+
+func (lr *sizeLimitReader) Read(p []byte) (int, error) {
+	// read one byte more than allowed so we notice
+	// if the file is too big.
+	if int64(len(p)) > lr.remaining+1 {
+		p = p[:lr.remaining+1]
+	}
+
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	if lr.remaining < 0 {
+		return n + int(lr.remaining), fmt.Errorf("%w: file is larger than %d bytes", ErrLimitExceeded, lr.max)
+	}
+
+	return n, err
+}
@@ -0,0 +1,155 @@
+package conf
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeserializeContextLimits(t *testing.T) {
+	content := "[First]\nA=1\nB=2\n\n[Second]\nC=multi \\\nline \\\nvalue\n"
+
+	cases := []struct {
+		Config ParseConfig
+		Line   int
+	}{
+		{ParseConfig{}, 0},
+		{ParseConfig{MaxFileSize: int64(len(content))}, 0},
+		{ParseConfig{MaxFileSize: 10}, 2},
+		{ParseConfig{MaxSections: 2}, 0},
+		{ParseConfig{MaxSections: 1}, 5},
+		{ParseConfig{MaxOptionsPerSection: 2}, 0},
+		{ParseConfig{MaxOptionsPerSection: 1}, 3},
+		{ParseConfig{MaxContinuationLines: 2}, 0},
+		{ParseConfig{MaxContinuationLines: 1}, 6},
+	}
+
+	for idx, c := range cases {
+		f, err := DeserializeContext(context.Background(), "", strings.NewReader(content), c.Config)
+		if c.Line == 0 {
+			if assert.NoError(t, err, "case #%d", idx) {
+				assert.Len(t, f.Sections, 2, "case #%d", idx)
+			}
+			continue
+		}
+
+		var pe *ParseError
+		if assert.True(t, errors.As(err, &pe), "case #%d: %v", idx, err) {
+			assert.Equal(t, c.Line, pe.Line, "case #%d", idx)
+			assert.True(t, errors.Is(err, ErrLimitExceeded), "case #%d", idx)
+		}
+	}
+}
+
+func TestDeserializeContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := DeserializeContext(ctx, "", strings.NewReader("[First]\nA=1\n[Second]\nB=2\n"))
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = LoadFileContext(ctx, fstest.MapFS{"a.unit": {}}, "a.unit")
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestLoadDropInsContextLimits(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lib/test.unit.d/10-a.conf": {Data: []byte("[Test]\nA=1\n")},
+		"lib/test.unit.d/20-b.conf": {Data: []byte("[Test]\nA=1\nB=2\n")},
+	}
+
+	dropins, err := LoadDropInsContext(context.Background(), fsys, "test.unit", []string{"lib"}, ParseConfig{MaxOptionsPerSection: 2})
+	require.NoError(t, err)
+	assert.Len(t, dropins, 2)
+
+	_, err = LoadDropInsContext(context.Background(), fsys, "test.unit", []string{"lib"}, ParseConfig{MaxOptionsPerSection: 1})
+	assert.True(t, errors.Is(err, ErrLimitExceeded))
+	assert.Contains(t, err.Error(), "20-b.conf")
+
+	_, err = ReadDirFS(fsys, "lib/test.unit.d", ".conf", nil, ReadDirConfig{Parse: ParseConfig{MaxOptionsPerSection: 1}})
+	assert.True(t, errors.Is(err, ErrLimitExceeded))
+}
+
+func TestDeserializeContextLineLength(t *testing.T) {
+	long := strings.Repeat("x", 3*SystemdLineMax)
+	content := "[Test]\nShort=1\nCert=" + long + "\n"
+
+	_, err := DeserializeContext(context.Background(), "", strings.NewReader(content))
+	assert.True(t, errors.Is(err, ErrLineTooLong))
+
+	_, err = DeserializeContext(context.Background(), "", strings.NewReader(content), ParseConfig{MaxLineLength: 4 * SystemdLineMax})
+	assert.NoError(t, err)
+
+	_, err = DeserializeContext(context.Background(), "", strings.NewReader(content), ParseConfig{MaxLineLength: 32})
+	assert.True(t, errors.Is(err, ErrLineTooLong))
+
+	f, err := DeserializeContext(context.Background(), "", strings.NewReader(content), RelaxedParseConfig())
+	if assert.NoError(t, err) {
+		assert.Equal(t, long, f.Sections[0].Options[1].Value)
+	}
+}
+
+func TestDeserializeContextLineLengthBoundary(t *testing.T) {
+	cfg := ParseConfig{MaxLineLength: 16}
+
+	// the limit includes the newline.
+	_, err := DeserializeContext(context.Background(), "", strings.NewReader("[Test]\nKey=01234567890\n"), cfg)
+	assert.NoError(t, err)
+
+	for _, content := range []string{
+		"[Test]\nKey=012345678901\n",
+		"[Test]\n# comment that is too long\n",
+		"[Test]\n[Section-name-too-long]\n",
+		"[Test]\nKey=short \\\n  continuation line too long\n",
+	} {
+		_, err = DeserializeContext(context.Background(), "", strings.NewReader(content), cfg)
+
+		// ErrLineTooLong is not wrapped so it can still be
+		// compared using ==.
+		assert.Equal(t, ErrLineTooLong, err, content)
+	}
+}
+
+func TestDeserializeContextLineLengthAllocation(t *testing.T) {
+	cfg := ParseConfig{MaxLineLength: 64 << 20}
+	content := "[Test]\nKey=value\n"
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	for i := 0; i < 4; i++ {
+		_, err := DeserializeContext(context.Background(), "", strings.NewReader(content), cfg)
+		require.NoError(t, err)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	// the line length limit must not be used to size buffers.
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+}
+
+func TestDeserializeContextComments(t *testing.T) {
+	content := "[Test]\n; semicolon\n// slashes\nA=1\n"
+
+	_, err := DeserializeContext(context.Background(), "", strings.NewReader(content))
+	assert.Error(t, err)
+
+	f, err := DeserializeContext(context.Background(), "", strings.NewReader(content), ParseConfig{CommentChars: "#;/"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, Options{{Name: "A", Value: "1"}}, f.Sections[0].Options)
+		assert.Equal(t, 4, f.Sections[0].OptionLine("A", 0))
+	}
+
+	_, err = DeserializeContext(context.Background(), "", strings.NewReader(content), ParseConfig{
+		CommentChars:        "#;/",
+		NoSemicolonComments: true,
+	})
+	assert.Error(t, err)
+}
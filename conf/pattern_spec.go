@@ -0,0 +1,85 @@
+package conf
+
+import (
+	"path"
+	"strings"
+)
+
+// SectionPattern describes the options allowed in all sections
+// whose name matches Pattern.
+type SectionPattern struct {
+	// Pattern is a glob pattern (see path.Match) that is matched
+	// case-insensitively against the section name. Use a trailing
+	// asterisk, like "Plugin.*", to match all sections with a given
+	// prefix. Invalid patterns never match.
+	Pattern string
+
+	// Options defines all options allowed in matching sections.
+	Options OptionRegistry
+}
+
+// PatternSpec is a SectionRegistry for files with dynamically named
+// sections like [Plugin.redis] and [Plugin.pg]. Sections are looked
+// up in Sections first, then matched against Patterns in order and
+// finally fall back to Fallback, if set:
+//
+//	spec := conf.PatternSpec{
+//		Sections: conf.FileSpec{
+//			"global": globalSpec,
+//		},
+//		Patterns: []conf.SectionPattern{
+//			{Pattern: "Plugin.*", Options: pluginSpec},
+//		},
+//	}
+//
+// Since PatternSpec implements SectionRegistry it can be used with
+// ValidateFile, ApplyDropIns and DecodeFile.
+type PatternSpec struct {
+	// Sections holds the specifications of sections with a
+	// fixed name.
+	Sections FileSpec
+
+	// Patterns holds the specifications of dynamically named
+	// sections. The first matching pattern wins.
+	Patterns []SectionPattern
+
+	// Fallback, if set, is used for all sections that are neither
+	// defined in Sections nor match any pattern. If nil, such
+	// sections are unknown.
+	Fallback OptionRegistry
+}
+
+// OptionsForSection implements SectionRegistry.
+func (spec PatternSpec) OptionsForSection(name string) (OptionRegistry, bool) {
+	if spec.Sections != nil {
+		if reg, ok := spec.Sections.OptionsForSection(name); ok {
+			return reg, true
+		}
+	}
+
+	key := strings.ToLower(name)
+	for _, p := range spec.Patterns {
+		if matchSectionPattern(p.Pattern, key) {
+			return p.Options, true
+		}
+	}
+
+	if spec.Fallback != nil {
+		return spec.Fallback, true
+	}
+
+	return nil, false
+}
+
+// matchSectionPattern returns true if the lower-case section name
+// matches pattern.
+func matchSectionPattern(pattern, name string) bool {
+	ok, err := path.Match(strings.ToLower(pattern), name)
+	return err == nil && ok
+}
+
+// isSectionPattern returns true if name contains glob meta
+// characters.
+func isSectionPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
@@ -0,0 +1,114 @@
+package conf
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatternSpecOptionsForSection(t *testing.T) {
+	global := SectionSpec{{Name: "LogLevel", Type: StringType}}
+	plugin := SectionSpec{{Name: "Address", Type: StringType}}
+	fallback := SectionSpec{{Name: "Any", Type: StringType}}
+
+	spec := PatternSpec{
+		Sections: FileSpec{"Global": global},
+		Patterns: []SectionPattern{
+			{Pattern: "[", Options: fallback},
+			{Pattern: "Plugin.*", Options: plugin},
+		},
+	}
+
+	reg, ok := spec.OptionsForSection("global")
+	assert.True(t, ok)
+	assert.Equal(t, global, reg)
+
+	reg, ok = spec.OptionsForSection("plugin.redis")
+	assert.True(t, ok)
+	assert.Equal(t, plugin, reg)
+
+	_, ok = spec.OptionsForSection("plugin")
+	assert.False(t, ok)
+
+	spec.Fallback = fallback
+	reg, ok = spec.OptionsForSection("plugin")
+	assert.True(t, ok)
+	assert.Equal(t, fallback, reg)
+}
+
+func TestPatternSpecFile(t *testing.T) {
+	spec := PatternSpec{
+		Sections: FileSpec{
+			"global": SectionSpec{{Name: "LogLevel", Type: StringType}},
+		},
+		Patterns: []SectionPattern{
+			{
+				Pattern: "Plugin.*",
+				Options: SectionSpec{
+					{Name: "Address", Type: StringType, Required: true},
+					{Name: "Timeout", Type: DurationType, Default: "1s"},
+				},
+			},
+		},
+	}
+
+	content := "[Global]\nLogLevel=info\n[Plugin.redis]\nAddress=localhost:6379\n[Plugin.pg]\nAddress=localhost:5432\n"
+	f, err := DeserializeContext(context.Background(), "", strings.NewReader(content))
+	require.NoError(t, err)
+
+	require.NoError(t, ApplyDropIns(f, []*DropIn{
+		{Sections: Sections{{Name: "Plugin.pg", Options: Options{{Name: "Timeout", Value: "5s"}}}}},
+	}, spec))
+	require.NoError(t, ValidateFile(f, spec))
+
+	type Plugin struct {
+		Address string
+		Timeout time.Duration
+	}
+	var target struct {
+		Global struct {
+			LogLevel string
+		}
+		Redis   Plugin            `section:"Plugin.redis"`
+		Plugins map[string]Plugin `section:"Plugin.*"`
+		List    []*Plugin         `section:"plugin.*"`
+	}
+	require.NoError(t, DecodeFile(f, &target, spec))
+
+	assert.Equal(t, "info", target.Global.LogLevel)
+	assert.Equal(t, Plugin{Address: "localhost:6379", Timeout: time.Second}, target.Redis)
+	assert.Equal(t, map[string]Plugin{
+		"Plugin.redis": {Address: "localhost:6379", Timeout: time.Second},
+		"Plugin.pg":    {Address: "localhost:5432", Timeout: 5 * time.Second},
+	}, target.Plugins)
+	if assert.Len(t, target.List, 2) {
+		assert.Equal(t, "localhost:5432", target.List[1].Address)
+	}
+
+	// maps cannot hold sections that are defined multiple
+	// times while slices can.
+	f.Sections = append(f.Sections, Section{Name: "Plugin.redis", Arg: "replica", Options: Options{{Name: "Address", Value: "localhost:6380"}}})
+	var plugins struct {
+		Plugins map[string]Plugin `section:"Plugin.*"`
+	}
+	err = DecodeFile(f, &plugins, spec)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Plugin.redis is defined multiple times")
+	}
+
+	var list struct {
+		List []Plugin `section:"Plugin.*"`
+	}
+	require.NoError(t, DecodeFile(f, &list, spec))
+	assert.Len(t, list.List, 3)
+
+	// sections not covered by the spec are unknown.
+	f, err = DeserializeContext(context.Background(), "", strings.NewReader("[Other]\nKey=value\n"))
+	require.NoError(t, err)
+	assert.True(t, errors.Is(ValidateFile(f, spec), ErrUnknownSection))
+}
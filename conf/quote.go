@@ -0,0 +1,193 @@
+package conf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Unquote removes systemd style quoting from value and replaces
+// all C-style escape sequences. Parts of value may be enclosed in
+// double or single quotes to keep whitespace. Quotes are removed
+// while whitespace outside of quotes is kept as it is. The following
+// escape sequences are supported both inside and outside of quotes:
+//
+//	\a \b \f \n \r \t \v \\ \" \' \s (space)
+//	\xHH       a single byte in hexadecimal notation
+//	\NNN       a single byte in octal notation
+//	\uXXXX     a unicode code point
+//	\UXXXXXXXX a unicode code point
+//
+// A backslash at the end of a line (as used by continuation lines)
+// is removed together with the newline.
+func Unquote(value string) (string, error) {
+	var (
+		b     strings.Builder
+		quote byte
+	)
+
+	for idx := 0; idx < len(value); {
+		c := value[idx]
+
+		switch {
+		case c == '\\':
+			s, n, err := unescapeSequence(value[idx:])
+			if err != nil {
+				return "", fmt.Errorf("%w at offset %d", err, idx)
+			}
+			b.WriteString(s)
+			idx += n
+			continue
+
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+
+		case quote != 0 && c == quote:
+			quote = 0
+
+		default:
+			b.WriteByte(c)
+		}
+
+		idx++
+	}
+
+	if quote != 0 {
+		return "", ErrUnterminatedQuote
+	}
+
+	return b.String(), nil
+}
+
+// unescapeSequence decodes the escape sequence at the start of s
+// and returns the result and the number of bytes consumed.
+func unescapeSequence(s string) (string, int, error) {
+	if len(s) < 2 {
+		return "", 0, ErrInvalidEscape
+	}
+
+	switch c := s[1]; c {
+	case 'a':
+		return "\a", 2, nil
+	case 'b':
+		return "\b", 2, nil
+	case 'f':
+		return "\f", 2, nil
+	case 'n':
+		return "\n", 2, nil
+	case 'r':
+		return "\r", 2, nil
+	case 't':
+		return "\t", 2, nil
+	case 'v':
+		return "\v", 2, nil
+	case 's':
+		return " ", 2, nil
+	case '\\', '"', '\'':
+		return string(c), 2, nil
+	case '\n':
+		return "", 2, nil
+	case 'x':
+		if len(s) < 4 {
+			return "", 0, ErrInvalidEscape
+		}
+		x, err := strconv.ParseUint(s[2:4], 16, 8)
+		if err != nil {
+			return "", 0, ErrInvalidEscape
+		}
+		return string([]byte{byte(x)}), 4, nil
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if len(s) < n+2 {
+			return "", 0, ErrInvalidEscape
+		}
+		x, err := strconv.ParseUint(s[2:n+2], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(x)) {
+			return "", 0, ErrInvalidEscape
+		}
+		return string(rune(x)), n + 2, nil
+	case '0', '1', '2', '3':
+		if len(s) < 4 {
+			return "", 0, ErrInvalidEscape
+		}
+		x, err := strconv.ParseUint(s[1:4], 8, 8)
+		if err != nil {
+			return "", 0, ErrInvalidEscape
+		}
+		return string([]byte{byte(x)}), 4, nil
+	}
+
+	return "", 0, ErrInvalidEscape
+}
+
+// Quote returns value in a form that is restored by Unquote. If
+// value contains whitespace, quotes, backslashes or non-printable
+// characters it is enclosed in double quotes and escaped. Otherwise
+// value is returned as it is.
+func Quote(value string) string {
+	needsQuoting := strings.IndexFunc(value, func(r rune) bool {
+		return r == '"' || r == '\'' || r == '\\' ||
+			r == utf8.RuneError ||
+			unicode.IsSpace(r) ||
+			!unicode.IsPrint(r)
+	}) != -1
+
+	if !needsQuoting {
+		return value
+	}
+
+	// The escape sequences produced by strconv.Quote are a
+	// subset of what Unquote supports.
+	return strconv.Quote(value)
+}
+
+// UnquoteValues returns a copy of f where all values of options that
+// are annotated with QuotedValue() in reg have been unquoted (see
+// Unquote). Options that are not annotated are copied as they are.
+// Values of slice options that declare a list split mode are kept as
+// they are because quotes are needed to split them correctly. They
+// are unquoted by OptionSpec.SplitValues instead. Errors are returned
+// as *ValidationError.
+func UnquoteValues(f *File, reg SectionRegistry) (*File, error) {
+	result := f.Clone()
+
+	for _, sec := range result.Sections {
+		optReg, ok := reg.OptionsForSection(strings.ToLower(sec.Name))
+		if !ok || optReg == nil {
+			continue
+		}
+
+		// seen counts the values of each option to look
+		// up their line numbers.
+		seen := make(map[string]int)
+		for idx, opt := range sec.Options {
+			name := strings.ToLower(opt.Name)
+			n := seen[name]
+			seen[name]++
+
+			spec, ok := optReg.GetOption(name)
+			if !ok || !IsQuoted(spec) || spec.splitsValues() {
+				continue
+			}
+
+			val, err := Unquote(opt.Value)
+			if err != nil {
+				return nil, &ValidationError{
+					Section: sec.Name,
+					Option:  opt.Name,
+					Line:    sec.OptionLine(name, n),
+					Err:     err,
+				}
+			}
+
+			sec.Options[idx].Value = val
+		}
+	}
+
+	return result, nil
+}
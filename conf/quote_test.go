@@ -0,0 +1,123 @@
+package conf
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnquote(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected string
+		Err      error
+	}{
+		{`plain value`, "plain value", nil},
+		{`"a b" 'c'`, "a b c", nil},
+		{`"it's" 'say "hi"'`, `it's say "hi"`, nil},
+		{`foo" bar "baz`, "foo bar baz", nil},
+		{`\n\t\\\"\'\s\a\b\f\r\v`, "\n\t\\\"' \a\b\f\r\v", nil},
+		{`\x41\101\u00e9\U0001F600`, "AAé😀", nil},
+		{`"\x41 \u00e9"`, "A é", nil},
+		{"first \\\nsecond", "first second", nil},
+		{`"unterminated`, "", ErrUnterminatedQuote},
+		{`'unterminated`, "", ErrUnterminatedQuote},
+		{`trailing\`, "", ErrInvalidEscape},
+		{`\q`, "", ErrInvalidEscape},
+		{`\x4`, "", ErrInvalidEscape},
+		{`\xZZ`, "", ErrInvalidEscape},
+		{`\uD800`, "", ErrInvalidEscape},
+		{`\400`, "", ErrInvalidEscape},
+	}
+
+	for idx, c := range cases {
+		res, err := Unquote(c.Input)
+		if c.Err != nil {
+			assert.True(t, errors.Is(err, c.Err), "case #%d (%s): %v", idx, c.Input, err)
+			continue
+		}
+
+		assert.NoError(t, err, "case #%d (%s)", idx, c.Input)
+		assert.Equal(t, c.Expected, res, "case #%d (%s)", idx, c.Input)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{"", ""},
+		{"simple", "simple"},
+		{"/usr/bin/foo", "/usr/bin/foo"},
+		{"é", "é"},
+		{"a b", `"a b"`},
+		{"it's", `"it's"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\path`, `"C:\\path"`},
+		{"line\nbreak\t", `"line\nbreak\t"`},
+		{"\x00\xff", `"\x00\xff"`},
+	}
+
+	for idx, c := range cases {
+		quoted := Quote(c.Input)
+		assert.Equal(t, c.Expected, quoted, "case #%d", idx)
+
+		unquoted, err := Unquote(quoted)
+		assert.NoError(t, err, "case #%d", idx)
+		assert.Equal(t, c.Input, unquoted, "case #%d", idx)
+	}
+}
+
+func TestUnquoteValues(t *testing.T) {
+	spec := FileSpec{
+		"test": SectionSpec{
+			{
+				Name:        "Quoted",
+				Type:        StringSliceType,
+				Annotations: new(Annotation).With(QuotedValue()),
+			},
+			{
+				Name: "Raw",
+				Type: StringType,
+			},
+		},
+	}
+
+	content := "[Test]\nQuoted=\"a b\"\nQuoted='c\\td'\nRaw=\"a b\"\n"
+	f, err := Deserialize("", strings.NewReader(content))
+	require.NoError(t, err)
+
+	res, err := UnquoteValues(f, spec)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a b", "c\td"}, res.Sections[0].GetStringSlice("Quoted"))
+	assert.Equal(t, `"a b"`, res.Sections[0].GetStringSlice("Raw")[0])
+
+	// the original file is not modified.
+	assert.Equal(t, `"a b"`, f.Sections[0].GetStringSlice("Quoted")[0])
+
+	// values are quoted again when written.
+	buf := new(bytes.Buffer)
+	require.NoError(t, WriteSectionsTo(res.Sections, buf, WriteConfig{Spec: spec}))
+	assert.Equal(t, "[Test]\nQuoted= \"a b\"\nQuoted= \"c\\td\"\nRaw= \"a b\"\n", buf.String())
+
+	f, err = Deserialize("", buf)
+	require.NoError(t, err)
+	res2, err := UnquoteValues(f, spec)
+	require.NoError(t, err)
+	assert.Equal(t, res.Sections[0].GetStringSlice("Quoted"), res2.Sections[0].GetStringSlice("Quoted"))
+
+	f, err = Deserialize("", strings.NewReader("[Test]\nRaw=ok\nQuoted=\"broken\n"))
+	require.NoError(t, err)
+	_, err = UnquoteValues(f, spec)
+	var ve *ValidationError
+	if assert.True(t, errors.As(err, &ve)) {
+		assert.Equal(t, "Quoted", ve.Option)
+		assert.Equal(t, 3, ve.Line)
+		assert.True(t, errors.Is(err, ErrUnterminatedQuote))
+	}
+}
@@ -1,42 +1,490 @@
 package conf
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
+	"io/fs"
+	"os"
+	"path"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+// SymlinkPolicy defines how ReadDir handles symbolic links.
+type SymlinkPolicy int
+
+// All supported symlink policies.
+const (
+	// SymlinkFollowFiles follows symlinks that point to files but
+	// does not descend into symlinked directories. This is the
+	// default.
+	SymlinkFollowFiles SymlinkPolicy = iota
+
+	// SymlinkFollow follows all symlinks. Symlinked directories are
+	// only descended into if ReadDirConfig.Recursive is set. Links that
+	// point back to one of their parent directories are skipped.
+	SymlinkFollow
+
+	// SymlinkSkip ignores all symlinks.
+	SymlinkSkip
+)
+
+// maxReadDirDepth limits the depth of recursive directory walks.
+// It protects against symlink cycles that cannot be detected
+// because the file system does not expose file identities.
+const maxReadDirDepth = 64
+
+// ReadDirConfig can be passed to ReadDir and ReadDirFS to configure
+// which files are loaded.
+type ReadDirConfig struct {
+	// Recursive may be set to true to walk all sub-directories
+	// of directory.
+	Recursive bool
+
+	// Include holds glob patterns (see path.Match) of files that
+	// should be loaded. Patterns that contain a slash are matched
+	// against the slash separated path relative to directory while
+	// all other patterns are matched against the file name. If empty,
+	// all files are included. Files must always end in suffix.
+	Include []string
+
+	// Exclude holds glob patterns of files and directories that should
+	// be skipped. Patterns are matched the same way as Include.
+	Exclude []string
+
+	// Symlinks defines how symbolic links are handled.
+	Symlinks SymlinkPolicy
+
+	// ContinueOnError may be set to true to continue loading if a
+	// file cannot be read, parsed or validated. In that case all
+	// successfully loaded files are returned together with a
+	// FileErrors error that reports each file that failed.
+	ContinueOnError bool
+
+	// Parallelism is the maximum number of files that are parsed
+	// and validated concurrently. Zero or one means files are loaded
+	// sequentially while a negative value uses runtime.GOMAXPROCS.
+	// Results are always returned in the same order.
+	Parallelism int
+
+	// Parse configures the parser and the resource limits that are
+	// enforced for each file.
+	Parse ParseConfig
+}
+
+// FileErrorKind describes why a file failed to load.
+type FileErrorKind int
+
+// All supported file error kinds.
+const (
+	// ReadFailure is used if a file could not be read.
+	ReadFailure FileErrorKind = iota + 1
+
+	// ParseFailure is used if a file contains syntax errors.
+	ParseFailure
+
+	// ValidationFailure is used if a file does not conform to
+	// the specification.
+	ValidationFailure
+)
+
+func (kind FileErrorKind) String() string {
+	switch kind {
+	case ReadFailure:
+		return "read"
+	case ParseFailure:
+		return "parse"
+	case ValidationFailure:
+		return "validation"
+	}
+	return fmt.Sprintf("FileErrorKind(%d)", int(kind))
+}
+
+// FileError describes why a single file failed to load.
+type FileError struct {
+	// Path is the path of the file.
+	Path string
+
+	// Kind is the kind of failure.
+	Kind FileErrorKind
+
+	// Line is the line number that caused the error. It is zero if
+	// unknown or not related to a specific line.
+	Line int
+
+	// Section is the name of the section that failed validation,
+	// if any.
+	Section string
+
+	// Option is the name of the option that failed validation,
+	// if any.
+	Option string
+
+	// Err is the error that occurred.
+	Err error
+}
+
+func (fe *FileError) Error() string {
+	var parts []string
+	if fe.Line > 0 {
+		parts = append(parts, fmt.Sprintf("%s:%d", fe.Path, fe.Line))
+	} else {
+		parts = append(parts, fe.Path)
+	}
+	if fe.Section != "" {
+		parts = append(parts, fe.Section)
+	}
+	if fe.Option != "" {
+		parts = append(parts, fe.Option)
+	}
+	parts = append(parts, fe.Err.Error())
+
+	return strings.Join(parts, ": ")
+}
+
+// Unwrap returns the underlying error.
+func (fe *FileError) Unwrap() error {
+	return fe.Err
+}
+
+// FileErrors is a list of file errors as returned by ReadDir
+// if ReadDirConfig.ContinueOnError is set.
+type FileErrors []*FileError
+
+func (errs FileErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for idx, err := range errs {
+		msgs[idx] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
 // ReadDir parses all files in directory that end in suffix. The sections of each file
 // are validated against the spec map using the lowercase section name as the map key.
-// If spec is nil no validation is performed.
-func ReadDir(directory, suffix string, spec SectionRegistry) ([]*File, error) {
-	entries, err := ioutil.ReadDir(directory)
+// If spec is nil no validation is performed. Use opts to walk sub-directories, filter
+// files using glob patterns or configure how symlinks are handled. Files are returned
+// sorted by their path relative to directory. By default, ReadDir stops at the first
+// file that fails to load. See ReadDirConfig.ContinueOnError to load all valid files
+// instead.
+func ReadDir(directory, suffix string, spec SectionRegistry, opts ...ReadDirConfig) ([]*File, error) {
+	return ReadDirFS(osFS{}, directory, suffix, spec, opts...)
+}
+
+// ReadDirFS is like ReadDir but reads directory from fsys.
+func ReadDirFS(fsys fs.FS, directory, suffix string, spec SectionRegistry, opts ...ReadDirConfig) ([]*File, error) {
+	return ReadDirContext(context.Background(), fsys, directory, suffix, spec, opts...)
+}
+
+// ReadDirContext is like ReadDirFS but stops loading files once ctx
+// is cancelled. In that case ctx.Err() is returned.
+func ReadDirContext(ctx context.Context, fsys fs.FS, directory, suffix string, spec SectionRegistry, opts ...ReadDirConfig) ([]*File, error) {
+	var cfg ReadDirConfig
+	if len(opts) > 0 {
+		cfg = opts[0]
+	}
+
+	for _, pattern := range append(append([]string{}, cfg.Include...), cfg.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+	}
+
+	paths, err := findDirFiles(fsys, directory, suffix, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	var files []*File
-	for _, e := range entries {
-		if e.IsDir() {
+	results, err := loadDirFiles(ctx, fsys, directory, paths, spec, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// files that have been loaded while ctx has been cancelled
+	// might be incomplete.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		files []*File
+		errs  FileErrors
+	)
+	for idx, res := range results {
+		rel := paths[idx]
+		if res.err != nil {
+			if !cfg.ContinueOnError {
+				return files, fmt.Errorf("%s: %w", rel, res.err)
+			}
+
+			errs = append(errs, newFileError(path.Join(directory, rel), res.err))
 			continue
 		}
-		if !strings.HasSuffix(e.Name(), suffix) {
-			continue
+
+		files = append(files, res.file)
+	}
+
+	if len(errs) > 0 {
+		return files, errs
+	}
+
+	return files, nil
+}
+
+// dirFileResult is the result of loading a single file.
+type dirFileResult struct {
+	file *File
+	err  error
+}
+
+// loadDirFiles loads and validates all files in paths using up to
+// cfg.Parallelism workers. Results are returned in the order of paths.
+// Unless cfg.ContinueOnError is set, no more files are loaded after the
+// first failure and only the results up to and including the first
+// failure are returned.
+func loadDirFiles(ctx context.Context, fsys fs.FS, directory string, paths []string, spec SectionRegistry, cfg ReadDirConfig) ([]dirFileResult, error) {
+	results := make([]dirFileResult, len(paths))
+	load := func(idx int) error {
+		f, err := LoadFileContext(ctx, fsys, path.Join(directory, paths[idx]), cfg.Parse)
+		if err == nil {
+			err = ValidateFile(f, spec)
+		}
+		results[idx] = dirFileResult{file: f, err: err}
+		return err
+	}
+
+	workers := cfg.Parallelism
+	if workers < 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(paths) {
+		workers = len(paths)
+	}
+
+	if workers <= 1 {
+		for idx := range paths {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			if err := load(idx); err != nil && !cfg.ContinueOnError {
+				return results[:idx+1], nil
+			}
+		}
+
+		return results, nil
+	}
+
+	var (
+		wg     sync.WaitGroup
+		jobs   = make(chan int)
+		failed int32
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for idx := range jobs {
+				if err := load(idx); err != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+
+	// Jobs are dispatched in order so once a file failed to load
+	// all files before it have been dispatched as well. That way the
+	// first failure is always the same, no matter how jobs have been
+	// scheduled.
+	var err error
+	dispatched := 0
+L:
+	for idx := range paths {
+		if !cfg.ContinueOnError && atomic.LoadInt32(&failed) == 1 {
+			break
+		}
+
+		select {
+		case jobs <- idx:
+			dispatched++
+		case <-ctx.Done():
+			err = ctx.Err()
+			break L
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+
+	results = results[:dispatched]
+	if !cfg.ContinueOnError {
+		for idx, res := range results {
+			if res.err != nil {
+				return results[:idx+1], nil
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// newFileError returns a new file error for err that occurred
+// while loading the file at path. Errors caused by included files
+// are reported with the path of the included file.
+func newFileError(path string, err error) *FileError {
+	fe := &FileError{
+		Path: path,
+		Kind: ReadFailure,
+		Err:  err,
+	}
+
+	var (
+		pe *ParseError
+		ve *ValidationError
+	)
+	switch {
+	case errors.As(err, &pe):
+		fe.Kind = ParseFailure
+		if pe.Source != "" {
+			fe.Path = pe.Source
+		}
+		fe.Line = pe.Line
+		fe.Err = pe.Err
+	case errors.As(err, &ve):
+		fe.Kind = ValidationFailure
+		if ve.Source != "" {
+			fe.Path = ve.Source
 		}
+		fe.Line = ve.Line
+		fe.Section = ve.Section
+		fe.Option = ve.Option
+		fe.Err = ve.Err
+	}
+
+	return fe
+}
 
-		path := filepath.Join(directory, e.Name())
-		f, err := LoadFile(path)
+// findDirFiles returns the paths of all files in directory, relative
+// to directory, that should be loaded according to suffix and cfg.
+func findDirFiles(fsys fs.FS, directory, suffix string, cfg ReadDirConfig) ([]string, error) {
+	var result []string
+
+	var walk func(rel string, parents []fs.FileInfo) error
+	walk = func(rel string, parents []fs.FileInfo) error {
+		entries, err := fs.ReadDir(fsys, path.Join(directory, rel))
 		if err != nil {
-			return files, fmt.Errorf("%s: %w", e.Name(), err)
+			return err
 		}
 
-		if err := ValidateFile(f, spec); err != nil {
-			return files, fmt.Errorf("%s: %w", e.Name(), err)
+		for _, e := range entries {
+			entryPath := path.Join(rel, e.Name())
+			if matchesAny(cfg.Exclude, entryPath) {
+				continue
+			}
+
+			isDir := e.IsDir()
+			isLink := e.Type()&fs.ModeSymlink != 0
+
+			var info fs.FileInfo
+			if isLink {
+				if cfg.Symlinks == SymlinkSkip {
+					continue
+				}
+
+				info, err = fs.Stat(fsys, path.Join(directory, entryPath))
+				if err != nil {
+					// dangling links are ignored.
+					continue
+				}
+				isDir = info.IsDir()
+
+				if isDir && cfg.Symlinks != SymlinkFollow {
+					continue
+				}
+			}
+
+			if isDir {
+				if !cfg.Recursive || len(parents) >= maxReadDirDepth {
+					continue
+				}
+
+				if info == nil {
+					info, err = e.Info()
+					if err != nil {
+						return err
+					}
+				}
+				if isLink && isParentDir(info, parents) {
+					continue
+				}
+
+				if err := walk(entryPath, append(parents, info)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if !strings.HasSuffix(e.Name(), suffix) {
+				continue
+			}
+			if len(cfg.Include) > 0 && !matchesAny(cfg.Include, entryPath) {
+				continue
+			}
+
+			result = append(result, entryPath)
 		}
 
-		files = append(files, f)
+		return nil
 	}
 
-	return files, nil
+	var root []fs.FileInfo
+	if info, err := fs.Stat(fsys, directory); err == nil {
+		root = append(root, info)
+	}
+
+	if err := walk("", root); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// matchesAny returns true if rel matches one of patterns. Patterns
+// without a slash are matched against the base name of rel.
+func matchesAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+
+		// patterns have been validated already.
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isParentDir returns true if info describes the same directory as
+// one of parents.
+func isParentDir(info fs.FileInfo, parents []fs.FileInfo) bool {
+	for _, p := range parents {
+		if os.SameFile(info, p) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,273 @@
+package conf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readDirPaths(files []*File) []string {
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+func TestReadDirFSRecursive(t *testing.T) {
+	unit := &fstest.MapFile{Data: []byte("[Test]\nName=value\n")}
+	fsys := fstest.MapFS{
+		"hooks/b.hook":            unit,
+		"hooks/a.hook":            unit,
+		"hooks/readme.md":         unit,
+		"hooks/net/up.hook":       unit,
+		"hooks/net/down.hook":     unit,
+		"hooks/net/old/gone.hook": unit,
+		"hooks/disk/mount.hook":   unit,
+	}
+
+	spec := FileSpec{
+		"test": SectionSpec{
+			{Name: "Name", Type: StringType},
+		},
+	}
+
+	files, err := ReadDirFS(fsys, "hooks", ".hook", spec)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hooks/a.hook", "hooks/b.hook"}, readDirPaths(files))
+
+	files, err = ReadDirFS(fsys, "hooks", ".hook", spec, ReadDirConfig{Recursive: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"hooks/a.hook",
+		"hooks/b.hook",
+		"hooks/disk/mount.hook",
+		"hooks/net/down.hook",
+		"hooks/net/old/gone.hook",
+		"hooks/net/up.hook",
+	}, readDirPaths(files))
+
+	files, err = ReadDirFS(fsys, "hooks", "", spec, ReadDirConfig{
+		Recursive: true,
+		Include:   []string{"net/*.hook"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hooks/net/down.hook", "hooks/net/up.hook"}, readDirPaths(files))
+
+	files, err = ReadDirFS(fsys, "hooks", ".hook", spec, ReadDirConfig{
+		Recursive: true,
+		Include:   []string{"*n*"},
+		Exclude:   []string{"old", "up.hook"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hooks/disk/mount.hook", "hooks/net/down.hook"}, readDirPaths(files))
+
+	_, err = ReadDirFS(fsys, "hooks", ".hook", spec, ReadDirConfig{Include: []string{"["}})
+	assert.Error(t, err)
+}
+
+func TestReadDirSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "read-dir-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name string) {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte("[Test]\nName=value\n"), 0644))
+	}
+
+	write("units/a.unit")
+	write("other/b.unit")
+	write("other/sub/c.unit")
+	require.NoError(t, os.Symlink(filepath.Join(dir, "other/b.unit"), filepath.Join(dir, "units/link.unit")))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "other"), filepath.Join(dir, "units/other")))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "units"), filepath.Join(dir, "units/loop")))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "units/dangling.unit")))
+
+	root := filepath.Join(dir, "units")
+	rel := func(files []*File) []string {
+		var paths []string
+		for _, p := range readDirPaths(files) {
+			r, err := filepath.Rel(root, p)
+			require.NoError(t, err)
+			paths = append(paths, r)
+		}
+		return paths
+	}
+
+	files, err := ReadDir(root, ".unit", nil, ReadDirConfig{Recursive: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.unit", "link.unit"}, rel(files))
+
+	files, err = ReadDir(root, ".unit", nil, ReadDirConfig{Recursive: true, Symlinks: SymlinkFollow})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.unit", "link.unit", "other/b.unit", "other/sub/c.unit"}, rel(files))
+
+	files, err = ReadDir(root, ".unit", nil, ReadDirConfig{Recursive: true, Symlinks: SymlinkSkip})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.unit"}, rel(files))
+}
+
+func TestReadDirContinueOnError(t *testing.T) {
+	fsys := fstest.MapFS{
+		"units/a.unit": {Data: []byte("[Test]\nName=a\n")},
+		"units/b.unit": {Data: []byte("[Test]\nName=b\nName=again\n")},
+		"units/c.unit": {Data: []byte("# comment\n[Test]\n\nName\n")},
+		"units/d.unit": {Data: []byte("[Test]\nName=d\n\n[Unknown]\nKey=value\n")},
+		"units/e.unit": {Data: []byte("[Test]\nName=e\nPort=abc\n")},
+		"units/f.unit": {Data: []byte("[Test]\nName=f\n")},
+	}
+
+	spec := FileSpec{
+		"test": SectionSpec{
+			{Name: "Name", Type: StringType},
+			{Name: "Port", Type: IntType},
+		},
+	}
+
+	files, err := ReadDirFS(fsys, "units", ".unit", spec)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"units/a.unit"}, readDirPaths(files))
+
+	files, err = ReadDirFS(fsys, "units", ".unit", spec, ReadDirConfig{ContinueOnError: true})
+	assert.Equal(t, []string{"units/a.unit", "units/f.unit"}, readDirPaths(files))
+
+	var report FileErrors
+	require.True(t, errors.As(err, &report))
+	require.Len(t, report, 4)
+
+	assert.Equal(t, "units/b.unit", report[0].Path)
+	assert.Equal(t, ValidationFailure, report[0].Kind)
+	assert.Equal(t, 3, report[0].Line)
+	assert.Equal(t, "Test", report[0].Section)
+	assert.Equal(t, "Name", report[0].Option)
+	assert.True(t, errors.Is(report[0], ErrOptionAllowedOnce))
+
+	assert.Equal(t, "units/c.unit", report[1].Path)
+	assert.Equal(t, ParseFailure, report[1].Kind)
+	assert.Equal(t, 4, report[1].Line)
+
+	assert.Equal(t, "units/d.unit", report[2].Path)
+	assert.Equal(t, ValidationFailure, report[2].Kind)
+	assert.Equal(t, 4, report[2].Line)
+	assert.Equal(t, "Unknown", report[2].Section)
+	assert.True(t, errors.Is(report[2], ErrUnknownSection))
+
+	assert.Equal(t, "units/e.unit", report[3].Path)
+	assert.Equal(t, ValidationFailure, report[3].Kind)
+	assert.Equal(t, 3, report[3].Line)
+	assert.Equal(t, "units/e.unit:3: Test: Port: invalid number", report[3].Error())
+}
+
+func TestDeserializeLineNumbers(t *testing.T) {
+	content := "# comment\n\n[First]\nA=1\nB=multi \\\nline\nC=3\n\n[Second] \n; comment \\\n ignored\n E = 5\n"
+
+	f, err := Deserialize("", strings.NewReader(content))
+	require.NoError(t, err)
+	require.Len(t, f.Sections, 2)
+
+	assert.Equal(t, 3, f.Sections[0].Line)
+	assert.Equal(t, map[string][]int{"a": {4}, "b": {5}, "c": {7}}, f.Sections[0].OptionLines)
+	assert.Equal(t, 9, f.Sections[1].Line)
+	assert.Equal(t, 12, f.Sections[1].OptionLine("e", 0))
+	assert.Equal(t, "E", f.Sections[1].Options[0].Name)
+
+	_, err = Deserialize("", strings.NewReader("[Test]\nA=1\n[Broken\n"))
+	var pe *ParseError
+	if assert.True(t, errors.As(err, &pe)) {
+		assert.Equal(t, 3, pe.Line)
+	}
+}
+
+func benchmarkUnits(count int) fstest.MapFS {
+	fsys := make(fstest.MapFS, count)
+	for i := 0; i < count; i++ {
+		fsys[fmt.Sprintf("units/%04d.unit", i)] = &fstest.MapFile{
+			Data: []byte(fmt.Sprintf("[Test]\nName=unit-%d\nPort=%d\n\n[Other]\nName=other\n", i, i)),
+		}
+	}
+	return fsys
+}
+
+var benchmarkSpec = FileSpec{
+	"test": SectionSpec{
+		{Name: "Name", Type: StringType},
+		{Name: "Port", Type: IntType},
+	},
+	"other": SectionSpec{
+		{Name: "Name", Type: StringType},
+	},
+}
+
+func TestReadDirParallel(t *testing.T) {
+	fsys := benchmarkUnits(200)
+
+	sequential, err := ReadDirFS(fsys, "units", ".unit", benchmarkSpec)
+	require.NoError(t, err)
+	require.Len(t, sequential, 200)
+
+	parallel, err := ReadDirFS(fsys, "units", ".unit", benchmarkSpec, ReadDirConfig{Parallelism: 8})
+	require.NoError(t, err)
+	assert.Equal(t, sequential, parallel)
+
+	// break some files and make sure the first failure is always
+	// reported the same way.
+	fsys["units/0050.unit"] = &fstest.MapFile{Data: []byte("[Test]\nPort=abc\n")}
+	fsys["units/0150.unit"] = &fstest.MapFile{Data: []byte("[Test]\nPort=abc\n")}
+
+	for i := 0; i < 10; i++ {
+		files, err := ReadDirFS(fsys, "units", ".unit", benchmarkSpec, ReadDirConfig{Parallelism: 8})
+		assert.True(t, errors.Is(err, ErrInvalidNumber))
+		assert.Contains(t, err.Error(), "0050.unit")
+		assert.Len(t, files, 50)
+	}
+
+	files, err := ReadDirFS(fsys, "units", ".unit", benchmarkSpec, ReadDirConfig{
+		Parallelism:     -1,
+		ContinueOnError: true,
+	})
+	assert.Len(t, files, 198)
+	var report FileErrors
+	if assert.True(t, errors.As(err, &report)) && assert.Len(t, report, 2) {
+		assert.Equal(t, "units/0050.unit", report[0].Path)
+		assert.Equal(t, "units/0150.unit", report[1].Path)
+	}
+}
+
+func TestReadDirContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, parallelism := range []int{0, 4} {
+		files, err := ReadDirContext(ctx, benchmarkUnits(10), "units", ".unit", benchmarkSpec, ReadDirConfig{Parallelism: parallelism})
+		assert.Equal(t, context.Canceled, err)
+		assert.Nil(t, files)
+	}
+}
+
+func benchmarkReadDir(b *testing.B, parallelism int) {
+	fsys := benchmarkUnits(2000)
+	cfg := ReadDirConfig{Parallelism: parallelism}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ReadDirFS(fsys, "units", ".unit", benchmarkSpec, cfg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadDirSequential(b *testing.B)  { benchmarkReadDir(b, 0) }
+func BenchmarkReadDirParallel4(b *testing.B)   { benchmarkReadDir(b, 4) }
+func BenchmarkReadDirParallelMax(b *testing.B) { benchmarkReadDir(b, -1) }
@@ -0,0 +1,270 @@
+package conf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSectionArgs(t *testing.T) {
+	content := strings.Join([]string{
+		"[Remote \"origin\"]",
+		"URL=https://example.com/origin.git",
+		"[Remote 'my fork']",
+		"URL=https://example.com/fork.git",
+		"[Match host=*.example.com]",
+		"User=admin",
+		"[Core]",
+		"Bare=no",
+		"",
+	}, "\n")
+
+	// without SectionArgs the whole header is used as the name.
+	f, err := DeserializeContext(context.Background(), "", strings.NewReader(content))
+	require.NoError(t, err)
+	assert.Equal(t, "Remote \"origin\"", f.Sections[0].Name)
+	assert.Empty(t, f.Sections[0].Arg)
+
+	f, err = DeserializeContext(context.Background(), "", strings.NewReader(content), ParseConfig{SectionArgs: true})
+	require.NoError(t, err)
+	require.Len(t, f.Sections, 4)
+
+	var headers [][2]string
+	for _, sec := range f.Sections {
+		headers = append(headers, [2]string{sec.Name, sec.Arg})
+	}
+	assert.Equal(t, [][2]string{
+		{"Remote", "origin"},
+		{"Remote", "my fork"},
+		{"Match", "host=*.example.com"},
+		{"Core", ""},
+	}, headers)
+
+	spec := FileSpec{
+		"remote": SectionSpec{{Name: "URL", Type: StringType}},
+		"match":  SectionSpec{{Name: "User", Type: StringType}},
+		"core":   SectionSpec{{Name: "Bare", Type: BoolType}},
+	}
+	require.NoError(t, ValidateFile(f, spec))
+	assert.Equal(t, "my fork", f.Sections[1].Arg)
+
+	type Remote struct {
+		Name string `option:",arg"`
+		URL  string
+	}
+	type Match struct {
+		Pattern string `option:",arg"`
+		User    string
+	}
+	var target struct {
+		Remote []Remote
+		Match  Match
+	}
+	require.NoError(t, DecodeFile(f, &target, spec))
+	assert.Equal(t, []Remote{
+		{Name: "origin", URL: "https://example.com/origin.git"},
+		{Name: "my fork", URL: "https://example.com/fork.git"},
+	}, target.Remote)
+	assert.Equal(t, Match{Pattern: "host=*.example.com", User: "admin"}, target.Match)
+
+	// arguments survive writing and parsing again.
+	buf := new(bytes.Buffer)
+	require.NoError(t, WriteSectionsTo(f.Sections, buf))
+	assert.True(t, strings.HasPrefix(buf.String(), "[Remote origin]\n"), buf.String())
+
+	f2, err := DeserializeContext(context.Background(), "", buf, ParseConfig{SectionArgs: true})
+	require.NoError(t, err)
+	for idx := range f.Sections {
+		assert.Equal(t, f.Sections[idx].Header(), f2.Sections[idx].Header())
+	}
+}
+
+func TestSectionArgsInvalid(t *testing.T) {
+	_, err := DeserializeContext(context.Background(), "", strings.NewReader("[Core]\nBare=no\n[Remote \"origin]\n"), ParseConfig{SectionArgs: true})
+
+	var pe *ParseError
+	if assert.True(t, errors.As(err, &pe)) {
+		assert.Equal(t, 3, pe.Line)
+		assert.True(t, errors.Is(err, ErrUnterminatedQuote))
+	}
+}
+
+func TestSectionArgsRoundTrip(t *testing.T) {
+	spec := FileSpec{
+		"remote": SectionSpec{{Name: "URL", Type: StringType}},
+	}
+
+	type Remote struct {
+		Name string `option:",arg"`
+		URL  string
+	}
+	type Config struct {
+		Remote []Remote
+	}
+
+	in := Config{
+		Remote: []Remote{
+			{Name: "origin", URL: "https://example.com/origin.git"},
+			{Name: "my fork"},
+		},
+	}
+
+	f, err := ConvertToFile(in, "")
+	require.NoError(t, err)
+	assert.Equal(t, Sections{
+		{Name: "Remote", Arg: "origin", Options: Options{{Name: "URL", Value: "https://example.com/origin.git"}}},
+		{Name: "Remote", Arg: "my fork"},
+	}, f.Sections)
+	require.NoError(t, ValidateFile(f, spec))
+
+	var out Config
+	require.NoError(t, DecodeFile(f, &out, spec))
+	assert.Equal(t, in, out)
+}
+
+func TestSectionArgsDiff(t *testing.T) {
+	old := &File{Sections: Sections{{Name: "Remote", Arg: "a", Options: Options{{Name: "URL", Value: "x"}}}}}
+	updated := &File{Sections: Sections{{Name: "Remote", Arg: "b", Options: Options{{Name: "URL", Value: "x"}}}}}
+
+	diff := DiffFiles(old, updated)
+	require.Len(t, diff, 2)
+	assert.Equal(t, DiffRemoved, diff[0].Op)
+	assert.Equal(t, "a", diff[0].Arg)
+	assert.Equal(t, DiffAdded, diff[1].Op)
+	assert.Equal(t, "b", diff[1].Arg)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, diff.Render(buf, nil))
+	assert.Equal(t, "- [Remote a]\n  - URL=\"x\"\n+ [Remote b]\n  + URL=\"x\"\n", buf.String())
+}
+
+func TestSectionArgsDropIns(t *testing.T) {
+	spec := FileSpec{
+		"remote": SectionSpec{{Name: "URL", Type: StringType}},
+	}
+
+	f := &File{Sections: Sections{
+		{Name: "Remote", Arg: "origin", Options: Options{{Name: "URL", Value: "a"}}},
+		{Name: "Remote", Arg: "upstream", Options: Options{{Name: "URL", Value: "b"}}},
+	}}
+
+	require.NoError(t, ApplyDropIns(f, []*DropIn{
+		{Sections: Sections{{Name: "Remote", Arg: "origin", Options: Options{{Name: "URL", Value: "c"}}}}},
+	}, spec))
+	assert.Equal(t, []string{"c"}, f.Sections[0].GetStringSlice("URL"))
+	assert.Equal(t, []string{"b"}, f.Sections[1].GetStringSlice("URL"))
+
+	err := ApplyDropIns(f, []*DropIn{
+		{Sections: Sections{{Name: "Remote", Arg: "other", Options: Options{{Name: "URL", Value: "c"}}}}},
+	}, spec)
+	assert.True(t, errors.Is(err, ErrDropInSectionNotExists))
+
+	// sections that are defined multiple times cannot be updated
+	// using drop-ins.
+	f.Sections = append(f.Sections, Section{Name: "Remote", Arg: "origin"})
+	err = ApplyDropIns(f, []*DropIn{
+		{Sections: Sections{{Name: "Remote", Arg: "origin", Options: Options{{Name: "URL", Value: "d"}}}}},
+	}, spec)
+	assert.True(t, errors.Is(err, ErrDropInSectionNotAllowed))
+
+	// OverlayFile merges sections with the same argument and appends
+	// all others.
+	f = &File{Sections: Sections{
+		{Name: "Remote", Arg: "origin", Options: Options{{Name: "URL", Value: "a"}}},
+	}}
+	require.NoError(t, OverlayFile(f, &File{Sections: Sections{
+		{Name: "Remote", Arg: "upstream", Options: Options{{Name: "URL", Value: "b"}}},
+		{Name: "Remote", Arg: "origin", Options: Options{{Name: "URL", Value: "c"}}},
+	}}, spec))
+	assert.Equal(t, Sections{
+		{Name: "Remote", Arg: "origin", Options: Options{{Name: "URL", Value: "c"}}},
+		{Name: "Remote", Arg: "upstream", Options: Options{{Name: "URL", Value: "b"}}},
+	}, f.Sections)
+}
+
+func TestSectionArgsProvenance(t *testing.T) {
+	spec := FileSpec{
+		"remote": SectionSpec{{Name: "URL", Type: StringType}},
+	}
+
+	base := &File{Path: "base.conf", Sections: Sections{
+		{Name: "Remote", Arg: "origin", Options: Options{{Name: "URL", Value: "a"}}},
+		{Name: "Remote", Arg: "upstream", Options: Options{{Name: "URL", Value: "b"}}},
+	}}
+	override := &File{Path: "override.conf", Sections: Sections{
+		{Name: "Remote", Arg: "upstream", Options: Options{{Name: "URL", Value: "c"}}},
+	}}
+
+	res, err := NewLoader(spec).
+		Add(PriorityVendor, StaticLayer("vendor", base)).
+		Add(PriorityAdmin, StaticLayer("admin", override)).
+		Load(nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Origin{{Layer: "vendor", Path: "base.conf"}}, res.Provenance.LookupArg("remote", "origin", "url"))
+	assert.Equal(t, []Origin{{Layer: "admin", Path: "override.conf"}}, res.Provenance.LookupArg("Remote", "upstream", "URL"))
+	assert.Nil(t, res.Provenance.Lookup("remote", "url"))
+}
+
+func TestSectionArgsEnv(t *testing.T) {
+	spec := FileSpec{
+		"remote": SectionSpec{{Name: "URL", Type: StringType}},
+	}
+
+	f := &File{Sections: Sections{
+		{Name: "Remote", Arg: "origin", Options: Options{{Name: "URL", Value: "a"}}},
+	}}
+
+	_, err := EncodeToEnv("APP", f, spec)
+	assert.True(t, errors.Is(err, ErrSectionArgNotSupported))
+	assert.Contains(t, err.Error(), "Remote origin")
+}
+
+func TestSectionArgsInterpolate(t *testing.T) {
+	f := &File{Sections: Sections{
+		{Name: "Remote", Arg: "origin", Options: Options{{Name: "URL", Value: "https://example.com/origin.git"}}},
+		{Name: "Remote", Arg: "my fork", Options: Options{{Name: "URL", Value: "https://example.com/fork.git"}}},
+		{Name: "Match host=*.example.com", Options: Options{{Name: "User", Value: "admin"}}},
+		{Name: "Core", Options: Options{
+			{Name: "Origin", Value: "${Remote origin.URL}"},
+			{Name: "Fork", Value: "${remote \"my fork\".url}"},
+			{Name: "User", Value: "${Match host=*.example.com.User}"},
+			{Name: "Missing", Value: "${Remote.URL}"},
+		}},
+	}}
+
+	res, err := Interpolate(f, nil, InterpolationConfig{})
+	require.NoError(t, err)
+
+	core := res.Get("core")
+	assert.Equal(t, []string{"https://example.com/origin.git"}, core.GetStringSlice("Origin"))
+	assert.Equal(t, []string{"https://example.com/fork.git"}, core.GetStringSlice("Fork"))
+	assert.Equal(t, []string{"admin"}, core.GetStringSlice("User"))
+
+	// references without an argument do not match sections
+	// with an argument.
+	assert.Equal(t, []string{"${Remote.URL}"}, core.GetStringSlice("Missing"))
+}
+
+func TestSectionArgsBrackets(t *testing.T) {
+	f := &File{Sections: Sections{
+		{Name: "Remote", Arg: "a]b", Options: Options{{Name: "URL", Value: "x"}}},
+		{Name: "Remote", Arg: "[c] d", Options: Options{{Name: "URL", Value: "y"}}},
+	}}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, WriteSectionsTo(f.Sections, buf))
+	assert.Equal(t, "[Remote a\\x5db]\nURL= x\n[Remote \"[c\\x5d d\"]\nURL= y\n", buf.String())
+
+	parsed, err := DeserializeContext(context.Background(), "", buf, ParseConfig{SectionArgs: true})
+	require.NoError(t, err)
+	if assert.Len(t, parsed.Sections, 2) {
+		assert.Equal(t, "a]b", parsed.Sections[0].Arg)
+		assert.Equal(t, "[c] d", parsed.Sections[1].Arg)
+	}
+}
@@ -25,7 +25,7 @@ func (dec *SectionDecoder) AsMap(sec Section) map[string]interface{} {
 	res := make(map[string]interface{})
 
 	for _, opt := range dec.specs {
-		val := sec.GetAs(opt.Name, opt.Type)
+		val := sec.GetSpecAs(opt)
 		if val == nil {
 			continue
 		}
@@ -43,5 +43,5 @@ func (dec *SectionDecoder) Get(sec Section, name string) interface{} {
 		return nil
 	}
 
-	return sec.GetAs(spec.Name, spec.Type)
+	return sec.GetSpecAs(spec)
 }
@@ -0,0 +1,303 @@
+package conf
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// HostEnv provides access to the host environment that is
+// required to compute some of the standard specifiers. It's
+// mainly here to allow injecting a fake environment for
+// testing purposes.
+type HostEnv interface {
+	// Hostname returns the hostname of the running system.
+	Hostname() (string, error)
+
+	// MachineID returns the machine ID of the running system.
+	MachineID() (string, error)
+
+	// BootID returns the boot ID of the running system.
+	BootID() (string, error)
+
+	// CurrentUser returns the user running the process.
+	CurrentUser() (*user.User, error)
+
+	// Getenv returns the value of the environment variable key.
+	Getenv(key string) string
+}
+
+// OSHostEnv is the HostEnv of the running system.
+var OSHostEnv HostEnv = osHostEnv{}
+
+type osHostEnv struct{}
+
+func (osHostEnv) Hostname() (string, error) { return os.Hostname() }
+
+func (osHostEnv) MachineID() (string, error) {
+	return readIDFile("/etc/machine-id")
+}
+
+func (osHostEnv) BootID() (string, error) {
+	return readIDFile("/proc/sys/kernel/random/boot_id")
+}
+
+func (osHostEnv) CurrentUser() (*user.User, error) { return user.Current() }
+
+func (osHostEnv) Getenv(key string) string { return os.Getenv(key) }
+
+// readIDFile reads a 128-bit ID from path and returns it in
+// the same format used by systemd (hex without dashes).
+func readIDFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	id := strings.TrimSpace(string(content))
+	return strings.ReplaceAll(id, "-", ""), nil
+}
+
+// StandardSpecifiers provides the common systemd specifiers for
+// a given unit. Values are computed lazily when they are first
+// used and cached afterwards. The following specifiers are
+// supported:
+//
+//	%n  full unit name
+//	%N  unit name without the type suffix
+//	%p  prefix name
+//	%P  unescaped prefix name
+//	%i  instance name
+//	%I  unescaped instance name
+//	%f  unescaped file name (see UnescapePath)
+//	%H  hostname
+//	%l  short hostname (up to the first dot)
+//	%m  machine ID
+//	%b  boot ID
+//	%u  user name
+//	%U  user UID
+//	%h  user home directory
+//	%t  runtime directory root (/run or $XDG_RUNTIME_DIR)
+//	%S  state directory root (/var/lib or $XDG_STATE_HOME)
+//	%C  cache directory root (/var/cache or $XDG_CACHE_HOME)
+//	%L  log directory root (/var/log or $XDG_STATE_HOME/log)
+//	%E  configuration directory root (/etc or $XDG_CONFIG_HOME)
+//	%T  directory for temporary files ($TMPDIR or /tmp)
+//	%V  directory for larger and persistent temporary files ($TMPDIR or /var/tmp)
+//
+// Any specifier may be overwritten using Overrides.
+type StandardSpecifiers struct {
+	// UnitName is the name of the unit the specifiers are
+	// computed for.
+	UnitName string
+
+	// UserMode may be set to true to resolve directory specifiers
+	// like a systemd user manager (i.e. using XDG directories).
+	UserMode bool
+
+	// Env is the host environment used to compute host and user
+	// specific values. If nil, OSHostEnv is used.
+	Env HostEnv
+
+	// Overrides may hold specifier values that take precedence
+	// over the computed ones.
+	Overrides Specifiers
+
+	l     sync.Mutex
+	cache map[rune]string
+	user  *user.User
+}
+
+// NewStandardSpecifiers returns the standard specifiers for unitName
+// using the host environment of the running system.
+func NewStandardSpecifiers(unitName string) *StandardSpecifiers {
+	return &StandardSpecifiers{
+		UnitName: unitName,
+	}
+}
+
+// Specifier implements SpecifierProvider.
+func (std *StandardSpecifiers) Specifier(r rune) (string, bool, error) {
+	if val, ok := std.Overrides[r]; ok {
+		return val, true, nil
+	}
+
+	std.l.Lock()
+	defer std.l.Unlock()
+
+	return std.lookup(r)
+}
+
+// lookup returns the cached value of r or computes it.
+// The caller must hold std.l.
+func (std *StandardSpecifiers) lookup(r rune) (string, bool, error) {
+	if val, ok := std.cache[r]; ok {
+		return val, true, nil
+	}
+
+	val, ok, err := std.compute(r)
+	if err != nil || !ok {
+		return "", ok, err
+	}
+
+	if std.cache == nil {
+		std.cache = make(map[rune]string)
+	}
+	std.cache[r] = val
+
+	return val, true, nil
+}
+
+func (std *StandardSpecifiers) env() HostEnv {
+	if std.Env == nil {
+		return OSHostEnv
+	}
+	return std.Env
+}
+
+// currentUser returns the (cached) user running the process.
+// The caller must hold std.l.
+func (std *StandardSpecifiers) currentUser() (*user.User, error) {
+	if std.user != nil {
+		return std.user, nil
+	}
+
+	u, err := std.env().CurrentUser()
+	if err != nil {
+		return nil, err
+	}
+	std.user = u
+
+	return u, nil
+}
+
+func (std *StandardSpecifiers) compute(r rune) (string, bool, error) {
+	switch r {
+	case 'n', 'N', 'p', 'i':
+		val, ok := TemplateSpecifiers(std.UnitName)[r]
+		return val, ok, nil
+
+	case 'P', 'I':
+		escaped := 'p'
+		if r == 'I' {
+			escaped = 'i'
+		}
+		val, err := Unescape(TemplateSpecifiers(std.UnitName)[escaped])
+		return val, err == nil, err
+
+	case 'f':
+		names := TemplateSpecifiers(std.UnitName)
+		name := names['p']
+		if names['i'] != "" {
+			name = names['i']
+		}
+		val, err := UnescapePath(name)
+		return val, err == nil, err
+
+	case 'H':
+		val, err := std.env().Hostname()
+		return val, err == nil, err
+
+	case 'l':
+		val, ok, err := std.lookup('H')
+		if err != nil || !ok {
+			return "", ok, err
+		}
+		return strings.SplitN(val, ".", 2)[0], true, nil
+
+	case 'm':
+		val, err := std.env().MachineID()
+		return val, err == nil, err
+
+	case 'b':
+		val, err := std.env().BootID()
+		return val, err == nil, err
+
+	case 'u', 'U', 'h':
+		u, err := std.currentUser()
+		if err != nil {
+			return "", false, err
+		}
+		switch r {
+		case 'u':
+			return u.Username, true, nil
+		case 'U':
+			return u.Uid, true, nil
+		default:
+			return u.HomeDir, true, nil
+		}
+
+	case 't', 'S', 'C', 'L', 'E':
+		val, err := std.directory(r)
+		return val, err == nil, err
+
+	case 'T', 'V':
+		if tmp := std.env().Getenv("TMPDIR"); tmp != "" {
+			return tmp, true, nil
+		}
+		if r == 'T' {
+			return "/tmp", true, nil
+		}
+		return "/var/tmp", true, nil
+	}
+
+	return "", false, nil
+}
+
+// directory resolves the directory specifier r either for
+// the system or the user manager.
+func (std *StandardSpecifiers) directory(r rune) (string, error) {
+	if !std.UserMode {
+		switch r {
+		case 't':
+			return "/run", nil
+		case 'S':
+			return "/var/lib", nil
+		case 'C':
+			return "/var/cache", nil
+		case 'L':
+			return "/var/log", nil
+		default:
+			return "/etc", nil
+		}
+	}
+
+	if r == 't' {
+		dir := std.env().Getenv("XDG_RUNTIME_DIR")
+		if dir == "" {
+			return "", errors.New("XDG_RUNTIME_DIR not set")
+		}
+		return dir, nil
+	}
+
+	var (
+		key      string
+		fallback string
+		suffix   string
+	)
+	switch r {
+	case 'S':
+		key, fallback = "XDG_STATE_HOME", ".local/state"
+	case 'C':
+		key, fallback = "XDG_CACHE_HOME", ".cache"
+	case 'L':
+		key, fallback, suffix = "XDG_STATE_HOME", ".local/state", "log"
+	default:
+		key, fallback = "XDG_CONFIG_HOME", ".config"
+	}
+
+	dir := std.env().Getenv(key)
+	if dir == "" {
+		u, err := std.currentUser()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(u.HomeDir, fallback)
+	}
+
+	return filepath.Join(dir, suffix), nil
+}
@@ -0,0 +1,140 @@
+package conf
+
+import (
+	"errors"
+	"os/user"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeHostEnv struct {
+	calls map[string]int
+	env   map[string]string
+}
+
+func (f *fakeHostEnv) called(name string) {
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[name]++
+}
+
+func (f *fakeHostEnv) Hostname() (string, error) {
+	f.called("hostname")
+	return "host.example.com", nil
+}
+
+func (f *fakeHostEnv) MachineID() (string, error) {
+	f.called("machine-id")
+	return "0123456789abcdef0123456789abcdef", nil
+}
+
+func (f *fakeHostEnv) BootID() (string, error) {
+	f.called("boot-id")
+	return "", errors.New("not available")
+}
+
+func (f *fakeHostEnv) CurrentUser() (*user.User, error) {
+	f.called("user")
+	return &user.User{
+		Uid:      "1000",
+		Username: "alice",
+		HomeDir:  "/home/alice",
+	}, nil
+}
+
+func (f *fakeHostEnv) Getenv(key string) string {
+	return f.env[key]
+}
+
+func TestStandardSpecifiers(t *testing.T) {
+	env := &fakeHostEnv{}
+	std := &StandardSpecifiers{
+		UnitName: "web-server@blue.service",
+		Env:      env,
+	}
+
+	cases := []struct {
+		I string
+		O string
+	}{
+		{"%P", "web/server"},
+		{"%I", "blue"},
+		{"%n", "web-server@blue.service"},
+		{"%N", "web-server@blue"},
+		{"%p", "web-server"},
+		{"%i", "blue"},
+		{"%f", "/blue"},
+		{"%H", "host.example.com"},
+		{"%l", "host"},
+		{"%m", "0123456789abcdef0123456789abcdef"},
+		{"%u:%U:%h", "alice:1000:/home/alice"},
+		{"%t %S %C %L %E", "/run /var/lib /var/cache /var/log /etc"},
+		{"%T %V", "/tmp /var/tmp"},
+	}
+
+	for idx, c := range cases {
+		res, err := ExpandSpecifiers(c.I, std)
+		assert.NoError(t, err, "case #%d", idx)
+		assert.Equal(t, c.O, res, "case #%d", idx)
+	}
+
+	// values are computed lazily and only once
+	assert.Equal(t, 1, env.calls["hostname"])
+	assert.Equal(t, 1, env.calls["user"])
+	assert.Equal(t, 0, env.calls["boot-id"])
+
+	_, err := ExpandSpecifiers("%b", std)
+	assert.Error(t, err)
+
+	_, err = ExpandSpecifiers("%z", std)
+	assert.Error(t, err)
+}
+
+func TestStandardSpecifiersEscaped(t *testing.T) {
+	std := &StandardSpecifiers{
+		UnitName: "mount@srv-my\\x20data.service",
+		Env:      &fakeHostEnv{},
+	}
+
+	res, err := ExpandSpecifiers("%i|%I|%f|%P", std)
+	assert.NoError(t, err)
+	assert.Equal(t, "srv-my\\x20data|srv/my data|/srv/my data|mount", res)
+
+	std = &StandardSpecifiers{
+		UnitName: "mount@invalid\\x.service",
+		Env:      &fakeHostEnv{},
+	}
+	_, err = ExpandSpecifiers("%I", std)
+	assert.Error(t, err)
+}
+
+func TestStandardSpecifiersUserMode(t *testing.T) {
+	std := &StandardSpecifiers{
+		UnitName: "backup.service",
+		UserMode: true,
+		Env: &fakeHostEnv{
+			env: map[string]string{
+				"XDG_RUNTIME_DIR": "/run/user/1000",
+				"XDG_CONFIG_HOME": "/home/alice/config",
+				"TMPDIR":          "/home/alice/tmp",
+			},
+		},
+		Overrides: Specifiers{
+			'H': "overwritten",
+		},
+	}
+
+	res, err := ExpandSpecifiers("%t %S %C %L %E %T %V %H %f", std)
+	assert.NoError(t, err)
+	assert.Equal(t, "/run/user/1000 /home/alice/.local/state /home/alice/.cache /home/alice/.local/state/log /home/alice/config /home/alice/tmp /home/alice/tmp overwritten /backup", res)
+
+	std = &StandardSpecifiers{
+		UnitName: "backup.service",
+		UserMode: true,
+		Env:      &fakeHostEnv{},
+	}
+	_, err = ExpandSpecifiers("%t", std)
+	assert.Error(t, err)
+}
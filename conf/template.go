@@ -2,7 +2,10 @@ package conf
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -37,34 +40,137 @@ func TemplateInstanceName(path string) (string, bool) {
 
 var specifierRe = regexp.MustCompile("%.")
 
-// Specifiers maps a alpha-numerical rune to a value.
-type Specifiers map[rune]string
+// SpecifierProvider provides the values for specifiers. Specifiers
+// and StandardSpecifiers are the default implementations.
+type SpecifierProvider interface {
+	// Specifier returns the value for the specifier r. If r is not
+	// supported by the provider false must be returned.
+	Specifier(r rune) (string, bool, error)
+}
 
-// Replace replaces all specifiers from sm in str and returns the result.
-// If an specifier is unknown an error is returned.
-func (sm Specifiers) Replace(str string) (string, error) {
-	var err error
-	res := specifierRe.ReplaceAllStringFunc(str, func(id string) string {
+// SpecifierError is returned if a specifier cannot be expanded.
+type SpecifierError struct {
+	// Section is the name of the section that contains the
+	// option. It's empty if the error is not associated with
+	// an option.
+	Section string
+
+	// Option is the name of the option that contains the
+	// specifier. It's empty if the error is not associated with
+	// an option.
+	Option string
+
+	// Specifier is the specifier that failed (like "%x").
+	Specifier string
+
+	// Offset is the byte offset of the specifier in the value.
+	Offset int
+
+	// Err is the error that occurred. It's ErrUnknownSpecifier
+	// if the specifier is not supported.
+	Err error
+}
+
+func (se *SpecifierError) Error() string {
+	msg := fmt.Sprintf("specifier %s at offset %d: %s", se.Specifier, se.Offset, se.Err)
+	if se.Option != "" {
+		msg = se.Section + "." + se.Option + ": " + msg
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (se *SpecifierError) Unwrap() error {
+	return se.Err
+}
+
+// SpecifierErrors is a list of specifier errors as returned
+// by ValidateSpecifiers.
+type SpecifierErrors []*SpecifierError
+
+func (errs SpecifierErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for idx, err := range errs {
+		msgs[idx] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ExpandSpecifiers replaces all specifiers in str with the values
+// provided by p. If a specifier is unknown or cannot be resolved
+// a *SpecifierError is returned.
+func ExpandSpecifiers(str string, p SpecifierProvider) (string, error) {
+	res, errs := expandSpecifiers(str, p, false)
+	if len(errs) > 0 {
+		return res, errs[0]
+	}
+	return res, nil
+}
+
+// expandSpecifiers expands all specifiers in str. If all is false
+// expansion stops at the first error.
+func expandSpecifiers(str string, p SpecifierProvider, all bool) (string, []*SpecifierError) {
+	var (
+		b    strings.Builder
+		errs []*SpecifierError
+		last int
+	)
+
+	for _, loc := range specifierRe.FindAllStringIndex(str, -1) {
+		b.WriteString(str[last:loc[0]])
+		last = loc[1]
+
+		id := str[loc[0]:loc[1]]
 		r := []rune(id)[1]
 		if r == '%' {
-			return "%"
+			b.WriteRune('%')
+			continue
 		}
-		val, ok := sm[rune(r)]
-		if !ok {
-			err = errors.New("Unknown specifier " + id)
-			return id
+
+		val, ok, err := p.Specifier(r)
+		if err == nil && !ok {
+			err = ErrUnknownSpecifier
+		}
+		if err != nil {
+			errs = append(errs, &SpecifierError{
+				Specifier: id,
+				Offset:    loc[0],
+				Err:       err,
+			})
+			if !all {
+				return str, errs
+			}
+			b.WriteString(id)
+			continue
 		}
-		return val
-	})
 
-	return res, err
+		b.WriteString(val)
+	}
+	b.WriteString(str[last:])
+
+	return b.String(), errs
+}
+
+// Specifiers maps a alpha-numerical rune to a value.
+type Specifiers map[rune]string
+
+// Specifier implements SpecifierProvider.
+func (sm Specifiers) Specifier(r rune) (string, bool, error) {
+	val, ok := sm[r]
+	return val, ok, nil
+}
+
+// Replace replaces all specifiers from sm in str and returns the result.
+// If an specifier is unknown an error is returned.
+func (sm Specifiers) Replace(str string) (string, error) {
+	return ExpandSpecifiers(str, sm)
 }
 
 // Get returns the value fro val.
 func (sm Specifiers) Get(val rune) (string, error) {
 	ret, ok := sm[val]
 	if !ok {
-		return "", errors.New("Unknown specifier %" + string(val))
+		return "", fmt.Errorf("%%%c: %w", val, ErrUnknownSpecifier)
 	}
 
 	return ret, nil
@@ -73,12 +179,12 @@ func (sm Specifiers) Get(val rune) (string, error) {
 // ReplaceSpecifiers replaces all specifiers in all section options
 // of f. If an unknown identifier is encountered an error is returned.
 // The original File f remains untouched.
-func ReplaceSpecifiers(f *File, sm Specifiers) (*File, error) {
+func ReplaceSpecifiers(f *File, sm SpecifierProvider) (*File, error) {
 	copy := f.Clone()
 	var err error
 	for _, sec := range copy.Sections {
 		for optIdx, opt := range sec.Options {
-			sec.Options[optIdx].Value, err = sm.Replace(opt.Value)
+			sec.Options[optIdx].Value, err = ExpandSpecifiers(opt.Value, sm)
 			if err != nil {
 				return nil, err
 			}
@@ -86,3 +192,216 @@ func ReplaceSpecifiers(f *File, sm Specifiers) (*File, error) {
 	}
 	return copy, nil
 }
+
+// ReplaceDeclaredSpecifiers is like ReplaceSpecifiers but only replaces
+// specifiers in options that are annotated with SpecifierValue() in
+// reg. All other options, including options of unknown sections, are
+// left untouched. Errors returned are of type *SpecifierError.
+// The original File f remains untouched.
+func ReplaceDeclaredSpecifiers(f *File, sm SpecifierProvider, reg SectionRegistry) (*File, error) {
+	copy := f.Clone()
+	for _, sec := range copy.Sections {
+		optReg, ok := reg.OptionsForSection(strings.ToLower(sec.Name))
+		if !ok || optReg == nil {
+			continue
+		}
+
+		for optIdx, opt := range sec.Options {
+			spec, ok := optReg.GetOption(strings.ToLower(opt.Name))
+			if !ok || !HasSpecifiers(spec) {
+				continue
+			}
+
+			val, errs := expandSpecifiers(opt.Value, sm, false)
+			if len(errs) > 0 {
+				errs[0].Section = sec.Name
+				errs[0].Option = opt.Name
+				return nil, errs[0]
+			}
+			sec.Options[optIdx].Value = val
+		}
+	}
+	return copy, nil
+}
+
+// ValidateSpecifiers checks that all specifiers used in options
+// annotated with SpecifierValue() can be expanded using sm. All
+// failing specifiers are reported with their section, option and
+// offset as SpecifierErrors.
+func ValidateSpecifiers(f *File, sm SpecifierProvider, reg SectionRegistry) error {
+	var result SpecifierErrors
+	for _, sec := range f.Sections {
+		optReg, ok := reg.OptionsForSection(strings.ToLower(sec.Name))
+		if !ok || optReg == nil {
+			continue
+		}
+
+		for _, opt := range sec.Options {
+			spec, ok := optReg.GetOption(strings.ToLower(opt.Name))
+			if !ok || !HasSpecifiers(spec) {
+				continue
+			}
+
+			_, errs := expandSpecifiers(opt.Value, sm, true)
+			for _, err := range errs {
+				err.Section = sec.Name
+				err.Option = opt.Name
+				result = append(result, err)
+			}
+		}
+	}
+
+	if len(result) > 0 {
+		return result
+	}
+	return nil
+}
+
+// TemplateName returns the name of the template unit for the template
+// instance unitName. For my-webserver@config-1.service TemplateName
+// returns "my-webserver@.service", true. If unitName is not a template
+// (instance) name false is returned.
+func TemplateName(unitName string) (string, bool) {
+	instance, ok := TemplateInstanceName(unitName)
+	if !ok {
+		return "", false
+	}
+
+	ext := filepath.Ext(unitName)
+	name := strings.TrimSuffix(filepath.Base(unitName), ext)
+	prefix := strings.TrimSuffix(name, "@"+instance)
+
+	return prefix + "@" + ext, true
+}
+
+// InstanceName returns the name of the unit that is created when
+// instantiating templateName with instance. For my-webserver@.service
+// and config-1 InstanceName returns "my-webserver@config-1.service".
+// If templateName is not a template unit name ErrNotTemplateInstance is
+// returned.
+func InstanceName(templateName, instance string) (string, error) {
+	inst, ok := TemplateInstanceName(templateName)
+	if !ok || inst != "" {
+		return "", fmt.Errorf("%s: %w", templateName, ErrNotTemplateInstance)
+	}
+
+	ext := filepath.Ext(templateName)
+	return strings.TrimSuffix(templateName, ext) + instance + ext, nil
+}
+
+// TemplateSpecifiers returns the specifiers that are available when
+// instantiating the template instance unitName. The following
+// specifiers are supported:
+//
+//	%n  full unit name (my-webserver@config-1.service)
+//	%N  unit name without the type suffix (my-webserver@config-1)
+//	%p  prefix name (my-webserver)
+//	%P  unescaped prefix name (see Unescape)
+//	%i  instance name (config-1)
+//	%I  unescaped instance name (see Unescape)
+//
+// If the prefix or instance name contains invalid escape sequences
+// the respective unescaped specifier is omitted.
+func TemplateSpecifiers(unitName string) Specifiers {
+	name := filepath.Base(unitName)
+	ext := filepath.Ext(name)
+	instance, _ := TemplateInstanceName(name)
+	prefix := strings.TrimSuffix(strings.TrimSuffix(name, ext), "@"+instance)
+
+	sm := Specifiers{
+		'n': name,
+		'N': strings.TrimSuffix(name, ext),
+		'p': prefix,
+		'i': instance,
+	}
+
+	if val, err := Unescape(prefix); err == nil {
+		sm['P'] = val
+	}
+	if val, err := Unescape(instance); err == nil {
+		sm['I'] = val
+	}
+
+	return sm
+}
+
+// LoadTemplateInstance loads the template instance unitName (like
+// my-webserver@config-1.service) from searchPath. If a unit file for
+// the instance itself exists it is used, otherwise the template unit
+// file (my-webserver@.service) is loaded. searchPath is ordered by
+// priority with lowest-priority first so a unit file found in a latter
+// directory is preferred. Once loaded, all template and instance
+// drop-ins from dropInSearchPath are applied (see DropInSearchPaths),
+// the standard systemd specifiers (see StandardSpecifiers) are replaced
+// in all options annotated with SpecifierValue() and the resulting file
+// is validated against spec.
+func LoadTemplateInstance(unitName string, searchPath, dropInSearchPath []string, spec SectionRegistry) (*File, error) {
+	return LoadTemplateInstanceFS(osFS{}, unitName, searchPath, dropInSearchPath, spec)
+}
+
+// LoadTemplateInstanceFS is like LoadTemplateInstance but loads
+// the unit file and all drop-ins from fsys.
+func LoadTemplateInstanceFS(fsys fs.FS, unitName string, searchPath, dropInSearchPath []string, spec SectionRegistry) (*File, error) {
+	instance, ok := TemplateInstanceName(unitName)
+	if !ok || instance == "" {
+		return nil, fmt.Errorf("%s: %w", unitName, ErrNotTemplateInstance)
+	}
+
+	templateName, _ := TemplateName(unitName)
+
+	path, err := findUnitFile(fsys, searchPath, unitName, templateName)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", unitName, err)
+	}
+
+	f, err := LoadFileFS(fsys, path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dropins, err := LoadDropInsFS(fsys, unitName, dropInSearchPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to load drop-ins: %w", unitName, err)
+	}
+
+	if err := ApplyDropIns(f, dropins, spec); err != nil {
+		return nil, fmt.Errorf("%s: failed to apply drop-ins: %w", unitName, err)
+	}
+
+	f, err = ReplaceDeclaredSpecifiers(f, NewStandardSpecifiers(unitName), spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", unitName, err)
+	}
+
+	if err := ValidateFile(f, spec); err != nil {
+		return nil, fmt.Errorf("%s: %w", unitName, err)
+	}
+
+	return f, nil
+}
+
+// findUnitFile searches searchPath in fsys for a file matching one of
+// names. Like systemd, each name is searched in all directories, starting
+// with the last (highest-priority) one, before falling back to the next
+// name. That is, an instance file always wins over its template.
+func findUnitFile(fsys fs.FS, searchPath []string, names ...string) (string, error) {
+	for _, name := range names {
+		for idx := len(searchPath) - 1; idx >= 0; idx-- {
+			unitPath := path.Join(filepath.ToSlash(searchPath[idx]), name)
+
+			stat, err := fs.Stat(fsys, unitPath)
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				return "", err
+			}
+
+			if !stat.IsDir() {
+				return unitPath, nil
+			}
+		}
+	}
+
+	return "", ErrUnitNotFound
+}
@@ -1,6 +1,8 @@
 package conf
 
 import (
+	"errors"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -72,3 +74,173 @@ func TestReplaceSpecifiers(t *testing.T) {
 		},
 	}, f)
 }
+
+func TestReplaceDeclaredSpecifiers(t *testing.T) {
+	spec := FileSpec{
+		"test": SectionSpec{
+			{Name: "Exec", Type: StringType, Annotations: new(Annotation).With(SpecifierValue())},
+			{Name: "Format", Type: StringType},
+		},
+	}
+
+	f := &File{
+		Sections: Sections{
+			{
+				Name: "Test",
+				Options: Options{
+					{Name: "Exec", Value: "/bin/%i"},
+					{Name: "Format", Value: "%Y-%m-%d"},
+				},
+			},
+			{
+				Name: "Unknown",
+				Options: Options{
+					{Name: "Exec", Value: "%i"},
+				},
+			},
+		},
+	}
+
+	res, err := ReplaceDeclaredSpecifiers(f, Specifiers{'i': "foo"}, spec)
+	assert.NoError(t, err)
+	assert.Equal(t, "/bin/foo", res.Sections[0].Options[0].Value)
+	assert.Equal(t, "%Y-%m-%d", res.Sections[0].Options[1].Value)
+	assert.Equal(t, "%i", res.Sections[1].Options[0].Value)
+
+	// f must not be modified
+	assert.Equal(t, "/bin/%i", f.Sections[0].Options[0].Value)
+
+	_, err = ReplaceDeclaredSpecifiers(f, Specifiers{}, spec)
+	assert.True(t, errors.Is(err, ErrUnknownSpecifier))
+}
+
+func TestValidateSpecifiers(t *testing.T) {
+	spec := FileSpec{
+		"test": SectionSpec{
+			{Name: "Exec", Type: StringSliceType, Annotations: new(Annotation).With(SpecifierValue())},
+			{Name: "Format", Type: StringType},
+		},
+	}
+
+	f := &File{
+		Sections: Sections{
+			{
+				Name: "Test",
+				Options: Options{
+					{Name: "Exec", Value: "/bin/%i %x"},
+					{Name: "Exec", Value: "100%% %y"},
+					{Name: "Format", Value: "%Y-%m-%d"},
+				},
+			},
+		},
+	}
+
+	err := ValidateSpecifiers(f, Specifiers{'i': "foo"}, spec)
+	if !assert.Error(t, err) {
+		return
+	}
+
+	errs, ok := err.(SpecifierErrors)
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Len(t, errs, 2)
+	assert.Equal(t, &SpecifierError{Section: "Test", Option: "Exec", Specifier: "%x", Offset: 8, Err: ErrUnknownSpecifier}, errs[0])
+	assert.Equal(t, &SpecifierError{Section: "Test", Option: "Exec", Specifier: "%y", Offset: 6, Err: ErrUnknownSpecifier}, errs[1])
+	assert.Equal(t, "Test.Exec: specifier %x at offset 8: unknown specifier; Test.Exec: specifier %y at offset 6: unknown specifier", err.Error())
+
+	assert.NoError(t, ValidateSpecifiers(f, Specifiers{'i': "foo", 'x': "x", 'y': "y"}, spec))
+}
+
+func TestTemplateName(t *testing.T) {
+	name, ok := TemplateName("my-webserver@config-1.service")
+	assert.True(t, ok)
+	assert.Equal(t, "my-webserver@.service", name)
+
+	name, ok = TemplateName("my-webserver@.service")
+	assert.True(t, ok)
+	assert.Equal(t, "my-webserver@.service", name)
+
+	_, ok = TemplateName("my-webserver.service")
+	assert.False(t, ok)
+}
+
+func TestInstanceName(t *testing.T) {
+	name, err := InstanceName("my-webserver@.service", "config-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "my-webserver@config-1.service", name)
+
+	_, err = InstanceName("my-webserver.service", "config-1")
+	assert.True(t, errors.Is(err, ErrNotTemplateInstance))
+
+	_, err = InstanceName("my-webserver@other.service", "config-1")
+	assert.True(t, errors.Is(err, ErrNotTemplateInstance))
+}
+
+func TestLoadTemplateInstance(t *testing.T) {
+	root, write := NewTestDir(t)
+
+	write("lib/web@.service", "[Service]\nName=%p\nInstance=%i\nUnit=%n\nFormat=%Y-%m-%d\n")
+	write("lib/web@.service.d/10-template.conf", "[Service]\nPort=80\n")
+	write("lib/web@blue.service.d/20-instance.conf", "[Service]\nPort=8080\n")
+
+	spec := FileSpec{
+		"service": SectionSpec{
+			{Name: "Name", Type: StringType, Annotations: new(Annotation).With(SpecifierValue())},
+			{Name: "Instance", Type: StringType, Annotations: new(Annotation).With(SpecifierValue())},
+			{Name: "Unit", Type: StringType, Annotations: new(Annotation).With(SpecifierValue())},
+			{Name: "Format", Type: StringType},
+			{Name: "Port", Type: IntType, Required: true},
+		},
+	}
+
+	lib := filepath.Join(root, "lib")
+	etc := filepath.Join(root, "etc")
+
+	f, err := LoadTemplateInstance("web@blue.service", []string{lib, etc}, []string{lib, etc}, spec)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, filepath.Join(lib, "web@.service"), f.Path)
+	sec := f.Get("Service")
+	if !assert.NotNil(t, sec) {
+		return
+	}
+	assert.Equal(t, []string{"web"}, sec.GetStringSlice("Name"))
+	assert.Equal(t, []string{"blue"}, sec.GetStringSlice("Instance"))
+	assert.Equal(t, []string{"web@blue.service"}, sec.GetStringSlice("Unit"))
+	assert.Equal(t, []string{"8080"}, sec.GetStringSlice("Port"))
+	assert.Equal(t, []string{"%Y-%m-%d"}, sec.GetStringSlice("Format"))
+
+	// an instance without the instance drop-in only gets the template
+	// drop-ins applied.
+	f, err = LoadTemplateInstance("web@green.service", []string{lib, etc}, []string{lib, etc}, spec)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"80"}, f.Get("Service").GetStringSlice("Port"))
+	}
+
+	// a unit file in a higher-priority directory takes precedence.
+	write("etc/web@.service", "[Service]\nName=etc-%p\nPort=1\n")
+	f, err = LoadTemplateInstance("web@green.service", []string{lib, etc}, []string{lib, etc}, spec)
+	if assert.NoError(t, err) {
+		assert.Equal(t, filepath.Join(etc, "web@.service"), f.Path)
+		assert.Equal(t, []string{"etc-web"}, f.Get("Service").GetStringSlice("Name"))
+		assert.Equal(t, []string{"80"}, f.Get("Service").GetStringSlice("Port"))
+	}
+
+	// an instance file in a lower-priority directory takes precedence
+	// over a template in a higher-priority one.
+	write("lib/web@red.service", "[Service]\nName=red-%p\nPort=2\n")
+	f, err = LoadTemplateInstance("web@red.service", []string{lib, etc}, nil, spec)
+	if assert.NoError(t, err) {
+		assert.Equal(t, filepath.Join(lib, "web@red.service"), f.Path)
+		assert.Equal(t, []string{"red-web"}, f.Get("Service").GetStringSlice("Name"))
+	}
+
+	_, err = LoadTemplateInstance("db@blue.service", []string{lib, etc}, nil, spec)
+	assert.True(t, errors.Is(err, ErrUnitNotFound))
+
+	_, err = LoadTemplateInstance("web@.service", []string{lib, etc}, nil, spec)
+	assert.True(t, errors.Is(err, ErrNotTemplateInstance))
+}
@@ -1,7 +1,6 @@
 package conf
 
 import (
-	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -14,12 +13,63 @@ type ValidationConfig struct {
 	IgnoreUnknownOptions  bool
 }
 
+// ValidationError is returned by ValidateFile and ValidateOptions
+// if a section or option does not conform to its specification.
+type ValidationError struct {
+	// Section is the name of the section. It is empty for errors
+	// returned by ValidateOptions.
+	Section string
+
+	// Option is the name of the option. It is empty if the error
+	// is not related to a single option.
+	Option string
+
+	// Line is the line number of the section or option that caused
+	// the error. Line numbers are looked up by ValidateFile (see
+	// Section.OptionLine) so Line is always zero for errors returned
+	// by ValidateOptions.
+	Line int
+
+	// Source is the path of the included file that contains the
+	// section. It is empty if the section has not been included.
+	Source string
+
+	// Err is the error that occurred.
+	Err error
+
+	// value is the index of the value of Option that caused
+	// the error. It's used to look up the line number.
+	value int
+}
+
+func (ve *ValidationError) Error() string {
+	var parts []string
+	if ve.Section != "" {
+		parts = append(parts, ve.Section)
+	}
+	if ve.Option != "" {
+		parts = append(parts, ve.Option)
+	}
+	parts = append(parts, ve.Err.Error())
+
+	return strings.Join(parts, ": ")
+}
+
+// Unwrap returns the underlying error.
+func (ve *ValidationError) Unwrap() error {
+	return ve.Err
+}
+
 // Prepare prepares the sec by applying default values and validating
 // options against a set of option specs.
 func Prepare(sec Section, specs OptionRegistry, opts ...ValidationConfig) (Section, error) {
 	var copy = Section{
-		Name:    sec.Name,
-		Options: ApplyDefaults(sec.Options, specs),
+		Name:        sec.Name,
+		Arg:         sec.Arg,
+		Line:        sec.Line,
+		Source:      sec.Source,
+		OptionLines: sec.OptionLines,
+		Options:     ApplyDefaults(sec.Options, specs),
 	}
 
 	if err := ValidateOptions(sec.Options, specs, opts...); err != nil {
@@ -31,7 +81,7 @@ func Prepare(sec Section, specs OptionRegistry, opts ...ValidationConfig) (Secti
 
 // ValidateFile validates all sections in file and applies any
 // default option values. If specs is nil then ValidateFile is
-// a no-op.
+// a no-op. Validation errors are returned as *ValidationError.
 func ValidateFile(file *File, specs SectionRegistry, opts ...ValidationConfig) error {
 	if specs == nil {
 		return nil
@@ -41,7 +91,12 @@ func ValidateFile(file *File, specs SectionRegistry, opts ...ValidationConfig) e
 		secSpec, ok := specs.OptionsForSection(strings.ToLower(section.Name))
 		if !ok {
 			if len(opts) == 0 || !opts[0].IgnoreUnknownSections {
-				return fmt.Errorf("%s: %w", section.Name, ErrUnknownSection)
+				return &ValidationError{
+					Section: section.Name,
+					Line:    section.Line,
+					Source:  section.Source,
+					Err:     ErrUnknownSection,
+				}
 			}
 
 			// copy the section as it is because we cannot validate it
@@ -49,6 +104,16 @@ func ValidateFile(file *File, specs SectionRegistry, opts ...ValidationConfig) e
 		} else {
 			sec, err := Prepare(section, secSpec, opts...)
 			if err != nil {
+				if ve, ok := err.(*ValidationError); ok {
+					ve.Section = section.Name
+					ve.Source = section.Source
+					if ve.Line == 0 && ve.Option != "" {
+						ve.Line = section.OptionLine(ve.Option, ve.value)
+					}
+					if ve.Line == 0 {
+						ve.Line = section.Line
+					}
+				}
 				return err
 			}
 			file.Sections[idx] = sec
@@ -99,7 +164,9 @@ func ApplyDefaults(options Options, specs OptionRegistry) Options {
 }
 
 // ValidateOptions validates if all unit options specified in sec conform
-// to the specification options.
+// to the specification options. Options are validated in the order they
+// first appear in options and validation errors are returned as
+// *ValidationError.
 func ValidateOptions(options Options, specs OptionRegistry, opts ...ValidationConfig) error {
 	lm := make(map[string]OptionSpec)
 	for _, spec := range specs.All() {
@@ -107,24 +174,48 @@ func ValidateOptions(options Options, specs OptionRegistry, opts ...ValidationCo
 	}
 
 	// group option values by option name.
-	gv := make(map[string][]string)
+	var order []string
+	gv := make(map[string][]Option)
 	for _, opt := range options {
 		n := strings.ToLower(opt.Name)
-		gv[n] = append(gv[n], opt.Value)
+		if _, ok := gv[n]; !ok {
+			order = append(order, n)
+		}
+		gv[n] = append(gv[n], opt)
 	}
 
 	// validate
-	for name, values := range gv {
-		spec, ok := lm[strings.ToLower(name)]
+	for _, name := range order {
+		group := gv[name]
+		spec, ok := lm[name]
 		if !ok {
-			// TODO(ppacher): we always use the lowercase version for the
-			// error message here, use the original one instead.
 			if len(opts) == 0 || !opts[0].IgnoreUnknownOptions {
-				return fmt.Errorf("%s: %w", name, ErrOptionNotExists)
+				return &ValidationError{
+					Option: group[0].Name,
+					Err:    ErrOptionNotExists,
+				}
 			}
 		} else {
-			if err := ValidateOption(values, spec); err != nil {
-				return fmt.Errorf("%s: %w", spec.Name, err)
+			values := make([]string, len(group))
+			for idx, opt := range group {
+				values[idx] = opt.Value
+			}
+
+			values, err := spec.SplitValues(values)
+			if err == nil {
+				err = ValidateOption(values, spec)
+			}
+			if err != nil {
+				ve := &ValidationError{
+					Option: spec.Name,
+					Err:    err,
+				}
+				// report the first value that is not allowed.
+				if err == ErrOptionAllowedOnce {
+					ve.value = 1
+				}
+
+				return ve
 			}
 
 			// delete the spec from the lookup map
@@ -136,9 +227,12 @@ func ValidateOptions(options Options, specs OptionRegistry, opts ...ValidationCo
 
 	// check if any option that is required is
 	// missing completely
-	for _, spec := range lm {
-		if spec.Required {
-			return fmt.Errorf("%s: %w", spec.Name, ErrOptionRequired)
+	for _, spec := range specs.All() {
+		if _, ok := lm[strings.ToLower(spec.Name)]; ok && spec.Required {
+			return &ValidationError{
+				Option: spec.Name,
+				Err:    ErrOptionRequired,
+			}
 		}
 	}
 
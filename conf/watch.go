@@ -0,0 +1,464 @@
+package conf
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReloadFunc loads, validates and decodes configuration. It's called
+// by a Watcher whenever a change has been detected. If an error is
+// returned the previous value is kept.
+type ReloadFunc func() (interface{}, error)
+
+// ChangeOp describes the kind of change of a file.
+type ChangeOp int
+
+// All supported change operations.
+const (
+	ChangeAdded ChangeOp = iota + 1
+	ChangeRemoved
+	ChangeModified
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	}
+	return fmt.Sprintf("ChangeOp(%d)", int(op))
+}
+
+// Change describes a change of a single file.
+type Change struct {
+	Path string
+	Op   ChangeOp
+}
+
+// Update is delivered to subscribers of a Watcher whenever
+// a change has been detected.
+type Update struct {
+	// Changes holds all file changes that triggered the update.
+	Changes []Change
+
+	// Value holds the new value returned by the ReloadFunc. It's
+	// nil if Err is set.
+	Value interface{}
+
+	// Err is set if the new configuration failed to load or
+	// validate. In that case the previous value is still active.
+	Err error
+}
+
+// WatchConfig can be passed to NewWatcher to configure how
+// changes are detected.
+type WatchConfig struct {
+	// Interval is the polling interval that is used if native file
+	// notifications (inotify on Linux) are not available. Defaults
+	// to two seconds.
+	Interval time.Duration
+
+	// ForcePolling disables native file notifications.
+	ForcePolling bool
+}
+
+// Watcher watches a set of files and directories and reloads the
+// configuration whenever a file has been added, removed or modified.
+// Directories are watched together with all their drop-in (*.d)
+// sub-directories. Invalid configuration is never swapped in.
+type Watcher struct {
+	paths  []string
+	reload ReloadFunc
+	cfg    WatchConfig
+
+	// checkLock serializes change checks.
+	checkLock sync.Mutex
+
+	l           sync.Mutex
+	current     interface{}
+	snapshot    map[string]uint64
+	subscribers []chan Update
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// NewWatcher returns a new watcher for paths that uses reload
+// to load the configuration. Use Start to start watching.
+func NewWatcher(paths []string, reload ReloadFunc, opts ...WatchConfig) *Watcher {
+	var cfg WatchConfig
+	if len(opts) > 0 {
+		cfg = opts[0]
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 2 * time.Second
+	}
+
+	return &Watcher{
+		paths:  paths,
+		reload: reload,
+		cfg:    cfg,
+	}
+}
+
+// WatchDir returns a watcher that loads all files in directory that
+// end in suffix (see ReadDir), applies all drop-ins found in
+// dropInSearchPath and validates the result against spec. Like
+// LoadTemplateInstance, files are only validated once all drop-ins
+// are applied so a drop-in may provide required options. The value
+// delivered to subscribers is of type []*File.
+func WatchDir(directory, suffix string, spec SectionRegistry, dropInSearchPath []string, opts ...WatchConfig) *Watcher {
+	reload := func() (interface{}, error) {
+		files, err := ReadDir(directory, suffix, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			unitName := filepath.Base(f.Path)
+
+			dropins, err := LoadDropIns(unitName, dropInSearchPath)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", unitName, err)
+			}
+
+			if err := ApplyDropIns(f, dropins, spec); err != nil {
+				return nil, fmt.Errorf("%s: %w", unitName, err)
+			}
+
+			if err := ValidateFile(f, spec); err != nil {
+				return nil, fmt.Errorf("%s: %w", unitName, err)
+			}
+		}
+
+		return files, nil
+	}
+
+	paths := append([]string{directory}, dropInSearchPath...)
+	return NewWatcher(paths, reload, opts...)
+}
+
+// Start loads the configuration for the first time and starts watching
+// for changes until ctx is cancelled or Stop is called. If the initial
+// configuration cannot be loaded an error is returned and the watcher
+// is not started.
+func (w *Watcher) Start(ctx context.Context) error {
+	snapshot, dirs := scanWatchPaths(w.paths)
+
+	val, err := w.reload()
+	if err != nil {
+		return err
+	}
+
+	w.l.Lock()
+	w.current = val
+	w.snapshot = snapshot
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.done = make(chan struct{})
+	w.l.Unlock()
+
+	var backend watchBackend
+	if !w.cfg.ForcePolling {
+		// if native notifications are not available we silently
+		// fall back to polling.
+		backend, err = newWatchBackend()
+		if err == nil {
+			if err := backend.Watch(dirs); err != nil {
+				backend.Close()
+				backend = nil
+			}
+		} else {
+			backend = nil
+		}
+	}
+
+	go w.watch(ctx, backend)
+
+	return nil
+}
+
+// Stop stops watching for changes and closes all subscriber
+// channels.
+func (w *Watcher) Stop() {
+	w.l.Lock()
+	cancel, done := w.cancel, w.done
+	w.l.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+}
+
+// Current returns the currently active configuration value.
+func (w *Watcher) Current() interface{} {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	return w.current
+}
+
+// Subscribe returns a channel that receives an Update whenever a
+// change has been detected. Slow subscribers only receive the most
+// recent update. The channel is closed when the watcher is stopped.
+func (w *Watcher) Subscribe() <-chan Update {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	ch := make(chan Update, 1)
+	w.subscribers = append(w.subscribers, ch)
+
+	return ch
+}
+
+// Check checks for changes immediately and reloads the configuration
+// if required. It's called automatically but may be used to force
+// a check.
+func (w *Watcher) Check() {
+	w.check(nil)
+}
+
+func (w *Watcher) watch(ctx context.Context, backend watchBackend) {
+	defer func() {
+		if backend != nil {
+			backend.Close()
+		}
+
+		w.l.Lock()
+		for _, ch := range w.subscribers {
+			close(ch)
+		}
+		w.subscribers = nil
+		close(w.done)
+		w.l.Unlock()
+	}()
+
+	var (
+		events <-chan struct{}
+		tick   <-chan time.Time
+	)
+
+	if backend != nil {
+		events = backend.Events()
+	} else {
+		ticker := time.NewTicker(w.cfg.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-tick:
+			w.check(nil)
+
+		case _, ok := <-events:
+			if !ok {
+				// the backend failed so fall back to polling.
+				backend.Close()
+				backend = nil
+				events = nil
+
+				ticker := time.NewTicker(w.cfg.Interval)
+				defer ticker.Stop()
+				tick = ticker.C
+				continue
+			}
+
+			// wait a bit for more events to arrive so we don't
+			// reload for each individual write.
+			debounce := time.NewTimer(100 * time.Millisecond)
+		L:
+			for {
+				select {
+				case _, ok := <-events:
+					if !ok {
+						debounce.Stop()
+						break L
+					}
+				case <-debounce.C:
+					break L
+				case <-ctx.Done():
+					debounce.Stop()
+					return
+				}
+			}
+
+			w.check(backend)
+		}
+	}
+}
+
+// check scans all watched paths and reloads the configuration if
+// something changed. If backend is not nil the set of watched
+// directories is updated.
+func (w *Watcher) check(backend watchBackend) {
+	w.checkLock.Lock()
+	defer w.checkLock.Unlock()
+
+	snapshot, dirs := scanWatchPaths(w.paths)
+
+	if backend != nil {
+		// errors are ignored here because there's nothing we
+		// could do. Directories are re-added on the next scan.
+		_ = backend.Watch(dirs)
+	}
+
+	w.l.Lock()
+	changes := diffSnapshots(w.snapshot, snapshot)
+	w.snapshot = snapshot
+	w.l.Unlock()
+
+	if len(changes) == 0 {
+		return
+	}
+
+	val, err := w.reload()
+	update := Update{
+		Changes: changes,
+		Err:     err,
+	}
+
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	if err == nil {
+		w.current = val
+		update.Value = val
+	}
+
+	for _, ch := range w.subscribers {
+		select {
+		case ch <- update:
+		default:
+			// drop the old update and deliver the new one.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- update
+		}
+	}
+}
+
+// scanWatchPaths returns a content hash for each file in paths
+// and all directories that need to be watched. Directories in
+// paths are scanned together with their *.d sub-directories.
+func scanWatchPaths(paths []string) (map[string]uint64, []string) {
+	snapshot := make(map[string]uint64)
+	var dirs []string
+
+	var scanDir func(dir string, recurse bool)
+	scanDir = func(dir string, recurse bool) {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		dirs = append(dirs, dir)
+
+		for _, e := range entries {
+			path := filepath.Join(dir, e.Name())
+			if e.IsDir() {
+				if recurse && strings.HasSuffix(e.Name(), ".d") {
+					scanDir(path, false)
+				}
+				continue
+			}
+
+			if hash, ok := hashFile(path); ok {
+				snapshot[path] = hash
+			}
+		}
+	}
+
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			// watch the parent directory so we notice when
+			// path is created.
+			if parent := filepath.Dir(path); parent != path {
+				if _, err := os.Stat(parent); err == nil {
+					dirs = append(dirs, parent)
+				}
+			}
+			continue
+		}
+
+		if stat.IsDir() {
+			scanDir(path, true)
+			continue
+		}
+
+		if hash, ok := hashFile(path); ok {
+			snapshot[path] = hash
+			dirs = append(dirs, filepath.Dir(path))
+		}
+	}
+
+	return snapshot, dirs
+}
+
+func hashFile(path string) (uint64, bool) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write(content)
+	return h.Sum64(), true
+}
+
+// diffSnapshots returns all changes between old and new sorted
+// by path.
+func diffSnapshots(old, new map[string]uint64) []Change {
+	var changes []Change
+	for path, hash := range new {
+		oldHash, ok := old[path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Path: path, Op: ChangeAdded})
+		case oldHash != hash:
+			changes = append(changes, Change{Path: path, Op: ChangeModified})
+		}
+	}
+
+	for path := range old {
+		if _, ok := new[path]; !ok {
+			changes = append(changes, Change{Path: path, Op: ChangeRemoved})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
+
+// watchBackend provides native file change notifications.
+type watchBackend interface {
+	// Watch updates the set of watched directories.
+	Watch(dirs []string) error
+
+	// Events returns a channel that receives a value whenever
+	// something in a watched directory changed.
+	Events() <-chan struct{}
+
+	// Close releases all resources of the backend.
+	Close() error
+}
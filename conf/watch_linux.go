@@ -0,0 +1,107 @@
+//go:build linux
+// +build linux
+
+package conf
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE |
+	syscall.IN_DELETE |
+	syscall.IN_MODIFY |
+	syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO |
+	syscall.IN_ATTRIB |
+	syscall.IN_DELETE_SELF
+
+// inotifyBackend implements watchBackend using inotify.
+type inotifyBackend struct {
+	file   *os.File
+	events chan struct{}
+
+	l   sync.Mutex
+	wds map[string]int
+}
+
+func newWatchBackend() (watchBackend, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &inotifyBackend{
+		// the file is non-blocking so reads use the runtime
+		// poller and Close unblocks pending reads.
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan struct{}, 1),
+		wds:    make(map[string]int),
+	}
+
+	go b.read()
+
+	return b, nil
+}
+
+func (b *inotifyBackend) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		_, err := b.file.Read(buf)
+		if err != nil {
+			close(b.events)
+			return
+		}
+
+		// we don't care about the actual events as
+		// the watcher re-scans all paths anyway.
+		select {
+		case b.events <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *inotifyBackend) Watch(dirs []string) error {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	keep := make(map[string]bool, len(dirs))
+	var firstErr error
+	for _, dir := range dirs {
+		keep[dir] = true
+		if _, ok := b.wds[dir]; ok {
+			continue
+		}
+
+		wd, err := syscall.InotifyAddWatch(int(b.file.Fd()), dir, inotifyMask)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+			}
+			continue
+		}
+		b.wds[dir] = wd
+	}
+
+	for dir, wd := range b.wds {
+		if !keep[dir] {
+			// the directory may already be gone in which
+			// case the watch has been removed by the kernel.
+			_, _ = syscall.InotifyRmWatch(int(b.file.Fd()), uint32(wd))
+			delete(b.wds, dir)
+		}
+	}
+
+	return firstErr
+}
+
+func (b *inotifyBackend) Events() <-chan struct{} {
+	return b.events
+}
+
+func (b *inotifyBackend) Close() error {
+	return b.file.Close()
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package conf
+
+import "errors"
+
+// newWatchBackend is not supported on this platform so the
+// Watcher always falls back to polling.
+func newWatchBackend() (watchBackend, error) {
+	return nil, errors.New("file notifications not supported")
+}
@@ -0,0 +1,133 @@
+package conf_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ppacher/system-conf/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchDir(t *testing.T) {
+	for _, polling := range []bool{false, true} {
+		polling := polling
+		name := "notify"
+		if polling {
+			name = "polling"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			testWatchDir(t, conf.WatchConfig{
+				Interval:     20 * time.Millisecond,
+				ForcePolling: polling,
+			})
+		})
+	}
+}
+
+func testWatchDir(t *testing.T, cfg conf.WatchConfig) {
+	root, write := conf.NewTestDir(t)
+
+	units := filepath.Join(root, "units")
+	dropins := filepath.Join(root, "dropins")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dropins, "a.hook.d"), 0755))
+
+	spec := conf.FileSpec{
+		"hook": conf.SectionSpec{
+			{Name: "Exec", Type: conf.StringType, Required: true},
+			{Name: "Timeout", Type: conf.DurationType},
+		},
+	}
+
+	write("units/a.hook", "[Hook]\nExec=/bin/a\n")
+
+	w := conf.WatchDir(units, ".hook", spec, []string{dropins}, cfg)
+	updates := w.Subscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if !assert.NoError(t, w.Start(ctx)) {
+		return
+	}
+	defer w.Stop()
+
+	assert.Len(t, w.Current().([]*conf.File), 1)
+
+	next := func() conf.Update {
+		select {
+		case u := <-updates:
+			return u
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for update")
+		}
+		return conf.Update{}
+	}
+
+	// add a new file
+	write("units/b.hook", "[Hook]\nExec=/bin/b\n")
+	u := next()
+	assert.NoError(t, u.Err)
+	assert.Equal(t, []conf.Change{{Path: filepath.Join(units, "b.hook"), Op: conf.ChangeAdded}}, u.Changes)
+	assert.Len(t, u.Value.([]*conf.File), 2)
+
+	// add a drop-in
+	write("dropins/a.hook.d/10-timeout.conf", "[Hook]\nTimeout=10s\n")
+	u = next()
+	assert.NoError(t, u.Err)
+	assert.Equal(t, []string{"10s"}, u.Value.([]*conf.File)[0].Get("hook").GetStringSlice("timeout"))
+
+	// an invalid file must not be swapped in
+	write("units/b.hook", "[Hook]\nTimeout=10s\n")
+	u = next()
+	assert.Error(t, u.Err)
+	assert.Nil(t, u.Value)
+	assert.Equal(t, []conf.Change{{Path: filepath.Join(units, "b.hook"), Op: conf.ChangeModified}}, u.Changes)
+	assert.Equal(t, []string{"/bin/b"}, w.Current().([]*conf.File)[1].Get("hook").GetStringSlice("exec"))
+
+	// removing the broken file fixes the configuration again
+	assert.NoError(t, os.Remove(filepath.Join(units, "b.hook")))
+	u = next()
+	assert.NoError(t, u.Err)
+	assert.Equal(t, []conf.Change{{Path: filepath.Join(units, "b.hook"), Op: conf.ChangeRemoved}}, u.Changes)
+	assert.Len(t, w.Current().([]*conf.File), 1)
+
+	w.Stop()
+	_, ok := <-updates
+	assert.False(t, ok)
+}
+
+func TestWatchDirValidatesAfterDropIns(t *testing.T) {
+	root, write := conf.NewTestDir(t)
+
+	spec := conf.FileSpec{
+		"hook": conf.SectionSpec{
+			{Name: "Exec", Type: conf.StringType, Required: true},
+			{Name: "Timeout", Type: conf.DurationType, Default: "1s"},
+		},
+	}
+
+	// the unit lacks the required Exec= option which is
+	// provided by a drop-in.
+	write("units/a.hook", "[Hook]\nTimeout=10s\n")
+	write("dropins/a.hook.d/10-exec.conf", "[Hook]\nExec=/bin/a\n")
+
+	w := conf.WatchDir(filepath.Join(root, "units"), ".hook", spec, []string{filepath.Join(root, "dropins")}, conf.WatchConfig{
+		ForcePolling: true,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if !assert.NoError(t, w.Start(ctx)) {
+		return
+	}
+	defer w.Stop()
+
+	files := w.Current().([]*conf.File)
+	if assert.Len(t, files, 1) {
+		assert.Equal(t, []string{"/bin/a"}, files[0].Get("hook").GetStringSlice("exec"))
+		assert.Equal(t, []string{"10s"}, files[0].Get("hook").GetStringSlice("timeout"))
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/ppacher/system-conf
 
-go 1.14
+go 1.16
 
 require (
 	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510 // indirect